@@ -0,0 +1,378 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	gmux "github.com/gorilla/mux"
+)
+
+// APIBook is a book as the /api/v1 routes send and receive it
+type APIBook struct {
+	PK             int64   `json:"pk"`
+	Title          string  `json:"title"`
+	Author         string  `json:"author"`
+	Classification string  `json:"classification"`
+	ID             string  `json:"id"` //OCLC work ID or the provider's own, see Source
+	ISBN10         string  `json:"isbn10"`
+	ISBN13         string  `json:"isbn13"`
+	Year           int     `json:"year"`
+	Publisher      string  `json:"publisher"`
+	Edition        string  `json:"edition"`
+	PageCount      int     `json:"page_count"`
+	Language       string  `json:"language"`
+	Notes          string  `json:"notes"`
+	Shelves        string  `json:"shelves"`
+	Rating         float64 `json:"rating"`
+	DateRead       string  `json:"date_read"`
+	Status         string  `json:"status"`
+}
+
+// APIBookInput is the body of POST and PATCH /api/v1/books. Fields left out are not changed;
+// a book created from Source and ID only is filled in by the provider in the background.
+type APIBookInput struct {
+	Title          *string  `json:"title"`
+	Author         *string  `json:"author"`
+	Classification *string  `json:"classification"`
+	ID             *string  `json:"id"`
+	Source         *string  `json:"source"` //metadata provider of ID, only when creating
+	ISBN           *string  `json:"isbn"`   //10 or 13 digits, stored as both
+	Year           *int     `json:"year"`
+	Publisher      *string  `json:"publisher"`
+	Edition        *string  `json:"edition"`
+	PageCount      *int     `json:"page_count"`
+	Language       *string  `json:"language"`
+	Notes          *string  `json:"notes"`
+	Shelves        *string  `json:"shelves"`
+	Rating         *float64 `json:"rating"`
+	DateRead       *string  `json:"date_read"`
+}
+
+// APIUser is the public part of a User
+type APIUser struct {
+	Username  string `json:"username"`
+	Verified  bool   `json:"verified"`
+	TwoFactor bool   `json:"two_factor"`
+}
+
+// APIBookList is a page of books. NextCursor, passed as cursor, gets the next page; it is
+// null on the last one.
+type APIBookList struct {
+	Books      []APIBook `json:"books"`
+	Total      int64     `json:"total"`
+	Limit      int       `json:"limit"`
+	Offset     int       `json:"offset"`
+	NextCursor *string   `json:"next_cursor"`
+}
+
+// APIError is the envelope of every error the API returns
+type APIError struct {
+	Error APIErrorBody `json:"error"`
+}
+
+type APIErrorBody struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, APIError{APIErrorBody{status, message}})
+}
+
+// requestError is http.Error for the middleware in front of both the pages and the API:
+// requests to /api/ get the error in the API's JSON envelope
+func requestError(w http.ResponseWriter, r *http.Request, message string, status int) {
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		writeAPIError(w, status, message)
+		return
+	}
+	http.Error(w, message, status)
+}
+
+func toAPIBook(b Book) APIBook {
+	return APIBook{
+		PK: b.PK, Title: b.Title, Author: b.Author, Classification: b.Classification, ID: b.ID,
+		ISBN10: b.ISBN10, ISBN13: b.ISBN13, Year: b.Year, Publisher: b.Publisher, Edition: b.Edition,
+		PageCount: b.PageCount, Language: b.Language, Notes: b.Notes, Shelves: b.Shelves,
+		Rating: b.Rating, DateRead: b.DateRead, Status: b.Status,
+	}
+}
+
+// decodeAPIBody reads a JSON request body into v, writing a 400 and returning false if it is not valid
+func decodeAPIBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		if err == io.EOF {
+			writeAPIError(w, http.StatusBadRequest, "request body must be a JSON object")
+		} else {
+			writeAPIError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		}
+		return false
+	}
+	return true
+}
+
+// apply copies the fields present in in onto b and validates the result, returning a message
+// for the client when it is not a valid book
+func (in APIBookInput) apply(b *Book) string {
+	set := func(dst *string, src *string) {
+		if src != nil {
+			*dst = strings.TrimSpace(*src)
+		}
+	}
+	set(&b.Title, in.Title)
+	set(&b.Author, in.Author)
+	set(&b.Classification, in.Classification)
+	set(&b.ID, in.ID)
+	set(&b.Publisher, in.Publisher)
+	set(&b.Edition, in.Edition)
+	set(&b.Language, in.Language)
+	set(&b.Shelves, in.Shelves)
+	if in.Notes != nil {
+		b.Notes = *in.Notes
+	}
+	if in.Year != nil {
+		b.Year = *in.Year
+	}
+	if in.PageCount != nil {
+		b.PageCount = *in.PageCount
+	}
+	if in.Rating != nil {
+		b.Rating = *in.Rating
+	}
+	if in.ISBN != nil {
+		if *in.ISBN == "" {
+			b.ISBN10, b.ISBN13 = "", ""
+		} else if isbn10, isbn13, err := parseISBN(*in.ISBN); err != nil {
+			return "isbn: " + err.Error()
+		} else {
+			b.ISBN10, b.ISBN13 = isbn10, isbn13
+		}
+	}
+	if in.DateRead != nil {
+		date, err := normalizeDate(*in.DateRead)
+		if err != nil {
+			return "date_read: " + err.Error()
+		}
+		b.DateRead = date
+	}
+
+	if b.Title == "" && b.Status != "pending" {
+		return "title must not be empty"
+	}
+	if !validClassification(b.Classification) {
+		return "classification must be a Dewey number such as 813.54"
+	}
+	if b.Rating < 0 || b.Rating > 5 {
+		return "rating must be between 0 and 5"
+	}
+	if b.Year < 0 || b.PageCount < 0 {
+		return "year and page_count must not be negative"
+	}
+	return ""
+}
+
+// getAPIBook loads one of the user's books, writing a 404 if there is no such book
+func getAPIBook(b *Book, w http.ResponseWriter, r *http.Request) bool {
+	pk, _ := strconv.ParseInt(gmux.Vars(r)["pk"], 10, 64)
+	err := dbmap.SelectOne(b, "select * from books where pk="+dbmap.Dialect.BindVar(0)+" and \"user\"="+dbmap.Dialect.BindVar(1),
+		pk, currentUser(r))
+	if err == sql.ErrNoRows {
+		writeAPIError(w, http.StatusNotFound, "no book with pk "+gmux.Vars(r)["pk"])
+		return false
+	} else if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return false
+	}
+	return true
+}
+
+// listAPIBooks answers GET /api/v1/books?filter=&sort=&limit=&offset=&cursor=
+func listAPIBooks(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := q.Get("filter")
+	switch filter {
+	case "", "all", "fiction", "nonfiction":
+	default:
+		writeAPIError(w, http.StatusBadRequest, "filter must be all, fiction or nonfiction")
+		return
+	}
+	page, msg := parseBookPage(r, bookPageSize)
+	if msg != "" {
+		writeAPIError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	keys, msg := parseBookSort(q.Get("sort"))
+	if msg != "" {
+		writeAPIError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	username := currentUser(r)
+	total, err := dbmap.SelectInt("select count(*) from books where \"user\"="+dbmap.Dialect.BindVar(0)+bookFilter(filter), username)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	var books []Book
+	if err = selectBooks(&books, keys, filter, username, &page); err == errInvalidCursor {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	} else if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	list := APIBookList{Books: make([]APIBook, 0, len(books)), Total: total, Limit: page.Limit, Offset: page.Offset}
+	for _, b := range books {
+		list.Books = append(list.Books, toAPIBook(b))
+	}
+	if page.Next != "" {
+		list.NextCursor = &page.Next
+	}
+	setPageLinks(w, r.URL.Path, r.URL.Query(), &page)
+	writeJSON(w, http.StatusOK, list)
+}
+
+// createAPIBook answers POST /api/v1/books. A body with source and id only adds a pending
+// book filled in by an enrich_book job, like the UI; otherwise the book is stored as sent,
+// and queued for Classify if it has no classification.
+func createAPIBook(w http.ResponseWriter, r *http.Request) {
+	var in APIBookInput
+	if !decodeAPIBody(w, r, &in) {
+		return
+	}
+	b := Book{PK: -1, User: currentUser(r)}
+	source := ""
+	if in.Source != nil {
+		source = *in.Source
+		if getProvider(source) == nil {
+			writeAPIError(w, http.StatusBadRequest, "unknown metadata provider: "+source)
+			return
+		}
+		if in.ID == nil || *in.ID == "" {
+			writeAPIError(w, http.StatusBadRequest, "id is required with source")
+			return
+		}
+		b.Status = "pending"
+	}
+	if msg := in.apply(&b); msg != "" {
+		writeAPIError(w, http.StatusUnprocessableEntity, msg)
+		return
+	}
+
+	tx, err := dbmap.Begin()
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err = tx.Insert(&b); err == nil {
+		if b.Status == "pending" {
+			err = enqueueJob(tx, "enrich_book", enrichBookPayload{b.PK, source, b.ID})
+		} else if b.Classification == "" {
+			err = enqueueJob(tx, "classify_book", classifyBookPayload{b.PK})
+		}
+	}
+	if err == nil {
+		err = tx.Commit()
+	} else {
+		tx.Rollback()
+	}
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Location", "/api/v1/books/"+strconv.FormatInt(b.PK, 10))
+	writeJSON(w, http.StatusCreated, toAPIBook(b))
+}
+
+// registerAPI adds the /api/v1 routes to mux
+func registerAPI(mux *gmux.Router) {
+	api := mux.PathPrefix("/api/v1").Subrouter()
+
+	api.HandleFunc("/books", listAPIBooks).Methods("GET")
+	api.HandleFunc("/books", createAPIBook).Methods("POST")
+
+	api.HandleFunc("/books/{pk:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		var b Book
+		if !getAPIBook(&b, w, r) {
+			return
+		}
+		writeJSON(w, http.StatusOK, toAPIBook(b))
+	}).Methods("GET")
+
+	api.HandleFunc("/books/{pk:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		var b Book
+		if !getAPIBook(&b, w, r) {
+			return
+		}
+		var in APIBookInput
+		if !decodeAPIBody(w, r, &in) {
+			return
+		}
+		if in.Source != nil {
+			writeAPIError(w, http.StatusUnprocessableEntity, "source can only be given when creating a book")
+			return
+		}
+		if msg := in.apply(&b); msg != "" {
+			writeAPIError(w, http.StatusUnprocessableEntity, msg)
+			return
+		}
+		if _, err := dbmap.Update(&b); err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, toAPIBook(b))
+	}).Methods("PATCH")
+
+	api.HandleFunc("/books/{pk:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		var b Book
+		if !getAPIBook(&b, w, r) {
+			return
+		}
+		if _, err := dbmap.Delete(&b); err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}).Methods("DELETE")
+
+	//  users can only see themselves; "me" stands for the authenticated user
+	api.HandleFunc("/users/{username}", func(w http.ResponseWriter, r *http.Request) {
+		username := gmux.Vars(r)["username"]
+		if username == "me" {
+			username = currentUser(r)
+		}
+		if username != currentUser(r) {
+			writeAPIError(w, http.StatusNotFound, "no user "+username)
+			return
+		}
+		obj, err := dbmap.Get(User{}, username)
+		if err != nil || obj == nil {
+			writeAPIError(w, http.StatusInternalServerError, "cannot load user "+username)
+			return
+		}
+		u := obj.(*User)
+		writeJSON(w, http.StatusOK, APIUser{Username: u.Username, Verified: u.Verified == 1, TwoFactor: u.TOTPEnabled == 1})
+	}).Methods("GET")
+
+	api.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, http.StatusNotFound, "no such API route: "+r.URL.Path)
+	})
+	api.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, http.StatusMethodNotAllowed, r.Method+" is not supported on "+r.URL.Path)
+	})
+}
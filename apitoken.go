@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/securecookie"
+)
+
+// APIToken is a row of the api_tokens table: a personal token for scripts, sent as
+// "Authorization: Bearer <token>". Only its hash is stored; the token is shown once.
+type APIToken struct {
+	ID         int64  `db:"id"`
+	Username   string `db:"username"`
+	Name       string `db:"name"`
+	TokenHash  string `db:"token_hash"`
+	Scope      string `db:"scope"` //"read" for GET requests only, or "write"
+	CreatedAt  int64  `db:"created_at"`
+	LastUsedAt int64  `db:"last_used_at"`
+}
+
+// prefix of every token, so one pasted somewhere it should not be is easy to spot
+const apiTokenPrefix = "gfwd_"
+
+// apiTokenTouchInterval limits how often a token's last used time is written
+const apiTokenTouchInterval = time.Minute
+
+type contextKey string
+
+// userContextKey holds the user a request was authenticated as by a token
+const userContextKey contextKey = "user"
+
+// Created and LastUsed are for templates
+func (t APIToken) Created() time.Time {
+	return time.Unix(t.CreatedAt, 0)
+}
+
+func (t APIToken) LastUsed() time.Time {
+	return time.Unix(t.LastUsedAt, 0)
+}
+
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// createAPIToken stores a new token for username and returns it
+func createAPIToken(username string, name string, scope string) (string, error) {
+	token := apiTokenPrefix + base64.RawURLEncoding.EncodeToString(securecookie.GenerateRandomKey(32))
+	t := &APIToken{
+		Username:  username,
+		Name:      name,
+		TokenHash: hashAPIToken(token),
+		Scope:     scope,
+		CreatedAt: time.Now().Unix(),
+	}
+	if err := dbmap.Insert(t); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func userAPITokens(username string) ([]APIToken, error) {
+	var tokens []APIToken
+	_, err := dbmap.Select(&tokens, "select * from api_tokens where username="+dbmap.Dialect.BindVar(0)+" order by created_at desc", username)
+	return tokens, err
+}
+
+// revokeAPIToken deletes one of the user's tokens, reporting whether there was one
+func revokeAPIToken(username string, id string) (bool, error) {
+	res, err := dbmap.Exec("delete from api_tokens where username="+dbmap.Dialect.BindVar(0)+" and id="+dbmap.Dialect.BindVar(1),
+		username, atoi(id))
+	if err != nil {
+		return false, err
+	}
+	deleted, _ := res.RowsAffected()
+	return deleted == 1, nil
+}
+
+// findAPIToken returns the stored token matching token, nil if there is none
+func findAPIToken(token string) (*APIToken, error) {
+	var tokens []APIToken
+	_, err := dbmap.Select(&tokens, "select * from api_tokens where token_hash="+dbmap.Dialect.BindVar(0), hashAPIToken(token))
+	if err != nil || len(tokens) == 0 {
+		return nil, err
+	}
+	return &tokens[0], nil
+}
+
+// verifyToken authenticates a request carrying an Authorization header. It never falls back
+// to the session cookie, which is what makes such requests safe to exempt from CSRF checks.
+func verifyToken(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="go-for-web-dev"`)
+		requestError(w, r, "Only bearer tokens are accepted", http.StatusUnauthorized)
+		return
+	}
+	t, err := findAPIToken(strings.TrimSpace(strings.TrimPrefix(auth, "Bearer ")))
+	if err != nil {
+		requestError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if t == nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="go-for-web-dev", error="invalid_token"`)
+		requestError(w, r, "Invalid or revoked token", http.StatusUnauthorized)
+		return
+	}
+	//  tokens are for the library and the API, not for managing the account
+	if r.URL.Path != "/books" && !strings.HasPrefix(r.URL.Path, "/books/") && !strings.HasPrefix(r.URL.Path, "/api/") {
+		requestError(w, r, "API tokens can only be used for /books and /api", http.StatusForbidden)
+		return
+	}
+	if t.Scope != "write" && r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="go-for-web-dev", error="insufficient_scope", scope="write"`)
+		requestError(w, r, "This token is read-only", http.StatusForbidden)
+		return
+	}
+
+	now := time.Now()
+	if now.Sub(time.Unix(t.LastUsedAt, 0)) > apiTokenTouchInterval {
+		if _, err := dbmap.Exec("update api_tokens set last_used_at="+dbmap.Dialect.BindVar(0)+" where id="+dbmap.Dialect.BindVar(1),
+			now.Unix(), t.ID); err != nil {
+			log.Printf("touching api token: %s", err)
+		}
+	}
+	next(w, r.WithContext(context.WithValue(r.Context(), userContextKey, t.Username)))
+}
+
+// currentUser is the user the request is authenticated as, by token or by session
+func currentUser(r *http.Request) string {
+	if username, ok := r.Context().Value(userContextKey).(string); ok {
+		return username
+	}
+	return getStringFromSession(r, "User")
+}
+
+// tokenAuthenticated reports whether the request came with an API token rather than a
+// session, in which case there is no session to remember preferences in
+func tokenAuthenticated(r *http.Request) bool {
+	_, ok := r.Context().Value(userContextKey).(string)
+	return ok
+}
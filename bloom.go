@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"strings"
+)
+
+// bloomMagic starts a bloom filter file, followed by the number of hash functions (uint32),
+// the number of bits (uint64), both big endian, and the bits
+const bloomMagic = "PWBLOOM1"
+
+// bloomFilter answers "definitely not in the set" or "probably in the set". Entries are
+// SHA-1 digests, the form breached password lists are published in.
+type bloomFilter struct {
+	k    uint32
+	bits []byte
+}
+
+func newBloomFilter(n int, falsePositiveRate float64) *bloomFilter {
+	if n < 1 {
+		n = 1
+	}
+	m := math.Ceil(-float64(n) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2))
+	k := math.Max(1, math.Round(m/float64(n)*math.Ln2))
+	return &bloomFilter{k: uint32(k), bits: make([]byte, (uint64(m)+7)/8)}
+}
+
+// positions derives the k bit positions from the digest by double hashing
+func (f *bloomFilter) positions(digest [sha1.Size]byte, visit func(bit uint64)) {
+	m := uint64(len(f.bits)) * 8
+	h1 := binary.BigEndian.Uint64(digest[0:8])
+	h2 := binary.BigEndian.Uint64(digest[8:16]) | 1
+	for i := uint64(0); i < uint64(f.k); i++ {
+		visit((h1 + i*h2) % m)
+	}
+}
+
+func (f *bloomFilter) add(digest [sha1.Size]byte) {
+	f.positions(digest, func(bit uint64) { f.bits[bit/8] |= 1 << (bit % 8) })
+}
+
+func (f *bloomFilter) mayContain(digest [sha1.Size]byte) bool {
+	found := true
+	f.positions(digest, func(bit uint64) {
+		if f.bits[bit/8]&(1<<(bit%8)) == 0 {
+			found = false
+		}
+	})
+	return found
+}
+
+func (f *bloomFilter) WriteTo(w io.Writer) (int64, error) {
+	header := make([]byte, len(bloomMagic)+12)
+	copy(header, bloomMagic)
+	binary.BigEndian.PutUint32(header[len(bloomMagic):], f.k)
+	binary.BigEndian.PutUint64(header[len(bloomMagic)+4:], uint64(len(f.bits))*8)
+	n, err := w.Write(header)
+	if err != nil {
+		return int64(n), err
+	}
+	m, err := w.Write(f.bits)
+	return int64(n + m), err
+}
+
+func readBloomFilter(path string) (*bloomFilter, error) {
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	headerLen := len(bloomMagic) + 12
+	if len(body) < headerLen || string(body[:len(bloomMagic)]) != bloomMagic {
+		return nil, errors.New(path + ": not a bloom filter file")
+	}
+	f := &bloomFilter{k: binary.BigEndian.Uint32(body[len(bloomMagic):]), bits: body[headerLen:]}
+	if m := binary.BigEndian.Uint64(body[len(bloomMagic)+4:]); m == 0 || m != uint64(len(f.bits))*8 || f.k == 0 {
+		return nil, errors.New(path + ": truncated or corrupt bloom filter")
+	}
+	return f, nil
+}
+
+// parseBreachedLine reads one line of a password list: a SHA-1 hex digest as in the
+// Have I Been Pwned download, optionally followed by ":count", or else a plain password
+func parseBreachedLine(line string) ([sha1.Size]byte, bool) {
+	var digest [sha1.Size]byte
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return digest, false
+	}
+	hash := strings.SplitN(line, ":", 2)[0]
+	if len(hash) == 2*sha1.Size {
+		if b, err := hex.DecodeString(hash); err == nil {
+			copy(digest[:], b)
+			return digest, true
+		}
+	}
+	return sha1.Sum([]byte(line)), true
+}
+
+// buildBloomFilter writes a filter of the passwords listed in in to out, for BREACHED_PASSWORDS.
+// The list is read twice, once to size the filter.
+func buildBloomFilter(in string, out string) error {
+	count := func(each func([sha1.Size]byte)) error {
+		file, err := os.Open(in)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			if digest, ok := parseBreachedLine(scanner.Text()); ok {
+				each(digest)
+			}
+		}
+		return scanner.Err()
+	}
+
+	n := 0
+	if err := count(func([sha1.Size]byte) { n++ }); err != nil {
+		return err
+	}
+	f := newBloomFilter(n, 0.001)
+	if err := count(f.add); err != nil {
+		return err
+	}
+
+	file, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	if _, err = f.WriteTo(file); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("%s: %d passwords, %d bits, %d hashes\n", out, n, len(f.bits)*8, f.k)
+	return nil
+}
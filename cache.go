@@ -0,0 +1,159 @@
+package main
+
+import (
+	"container/list"
+	"expvar"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cacheEntry is a cached provider response. NotFound entries are kept for a shorter time
+// so a book added to the catalog later is picked up.
+type cacheEntry struct {
+	Key       string `db:"cache_key"`
+	Body      []byte `db:"body"`
+	NotFound  int    `db:"not_found"` //1 for a cached "no such work", stored as an int for both dialects
+	ExpiresAt int64  `db:"expires_at"`
+}
+
+// responseCache is an in-memory LRU in front of the api_cache table. Lookups try memory first,
+// then the database, and only then the provider; a database hit is promoted to memory.
+type responseCache struct {
+	name        string
+	capacity    int
+	ttl         time.Duration
+	negativeTTL time.Duration
+
+	mu    sync.Mutex
+	lru   *list.List //of *cacheEntry, most recently used first
+	items map[string]*list.Element
+
+	stats *expvar.Map
+}
+
+// hit and miss counters per cache, served at /debug/vars
+var cacheStats = expvar.NewMap("cache")
+
+func newResponseCache(name string, capacity int, ttl, negativeTTL time.Duration) *responseCache {
+	c := &responseCache{
+		name:        name,
+		capacity:    capacity,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		lru:         list.New(),
+		items:       map[string]*list.Element{},
+		stats:       new(expvar.Map).Init(),
+	}
+	cacheStats.Set(name, c.stats)
+	return c
+}
+
+// cacheKey normalizes query parameters so differently cased or spaced searches share an entry
+func cacheKey(prefix string, params url.Values) string {
+	normalized := url.Values{}
+	for k, values := range params {
+		for _, v := range values {
+			normalized.Add(k, strings.Join(strings.Fields(strings.ToLower(v)), " "))
+		}
+	}
+	//  Encode sorts by key
+	return prefix + ":" + normalized.Encode()
+}
+
+// get returns the cached body for key and whether it was a "not found" response
+func (c *responseCache) get(key string) (body []byte, notFound bool, ok bool) {
+	now := time.Now().Unix()
+
+	c.mu.Lock()
+	if el, found := c.items[key]; found {
+		entry := el.Value.(*cacheEntry)
+		if entry.ExpiresAt > now {
+			c.lru.MoveToFront(el)
+			c.mu.Unlock()
+			c.stats.Add("memory_hits", 1)
+			return entry.Body, entry.NotFound == 1, true
+		}
+		c.lru.Remove(el)
+		delete(c.items, key)
+	}
+	c.mu.Unlock()
+
+	var entries []cacheEntry
+	_, err := dbmap.Select(&entries, "select * from api_cache where cache_key="+dbmap.Dialect.BindVar(0)+
+		" and expires_at>"+dbmap.Dialect.BindVar(1), key, now)
+	if err != nil {
+		log.Printf("%s cache: %s", c.name, err)
+	}
+	if len(entries) == 0 {
+		c.stats.Add("misses", 1)
+		return nil, false, false
+	}
+	c.stats.Add("db_hits", 1)
+	c.remember(&entries[0])
+	return entries[0].Body, entries[0].NotFound == 1, true
+}
+
+// set caches body under key in memory and in the database
+func (c *responseCache) set(key string, body []byte, notFound bool) {
+	entry := &cacheEntry{Key: key, Body: body}
+	ttl := c.ttl
+	if notFound {
+		entry.NotFound = 1
+		ttl = c.negativeTTL
+		c.stats.Add("negative_stores", 1)
+	}
+	entry.ExpiresAt = time.Now().Add(ttl).Unix()
+	c.remember(entry)
+
+	if err := storeCacheEntry(entry); err != nil {
+		log.Printf("%s cache: %s", c.name, err)
+	}
+}
+
+func (c *responseCache) remember(entry *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, found := c.items[entry.Key]; found {
+		el.Value = entry
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.items[entry.Key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.capacity {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheEntry).Key)
+	}
+}
+
+// replace rather than upsert, the vendored SQLite predates on conflict
+func storeCacheEntry(entry *cacheEntry) error {
+	tx, err := dbmap.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("delete from api_cache where cache_key="+dbmap.Dialect.BindVar(0), entry.Key)
+	if err == nil {
+		_, err = tx.Exec("insert into api_cache (cache_key, body, not_found, expires_at) values ("+
+			dbmap.Dialect.BindVar(0)+", "+dbmap.Dialect.BindVar(1)+", "+dbmap.Dialect.BindVar(2)+", "+dbmap.Dialect.BindVar(3)+")",
+			entry.Key, entry.Body, entry.NotFound, entry.ExpiresAt)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// purgeExpiredCache deletes expired api_cache rows every interval
+func purgeExpiredCache(interval time.Duration) {
+	for range time.Tick(interval) {
+		if _, err := dbmap.Exec("delete from api_cache where expires_at<="+dbmap.Dialect.BindVar(0), time.Now().Unix()); err != nil {
+			log.Printf("purging api_cache: %s", err)
+		}
+	}
+}
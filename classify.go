@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+)
+
+const defaultClassifyURL = "http://classify.oclc.org/classify2/Classify"
+
+type ClassifySearchResponse struct {
+	Results []SearchResult `xml:"works>work"`
+	//  set instead of Results when the query identifies a single work, e.g. an ISBN
+	Work SearchResult `xml:"work"`
+}
+
+type ClassifyBookResponse struct {
+	BookData struct {
+		Title     string `xml:"title,attr"`
+		Author    string `xml:"author,attr"`
+		ID        string `xml:"owi,attr"`
+		FirstYear string `xml:"lyr,attr"`
+	} `xml:"work"`
+	Classification struct {
+		MostPopular string `xml:"sfa,attr"`
+	} `xml:"recommendations>ddc>mostPopular"`
+}
+
+// ClassifyProvider talks to the OCLC Classify2 API, or anything serving the same XML at BaseURL
+type ClassifyProvider struct {
+	BaseURL string
+	Client  *http.Client
+	Cache   *responseCache //optional
+}
+
+func NewClassifyProvider(baseURL string) *ClassifyProvider {
+	return &ClassifyProvider{BaseURL: baseURL, Client: providerClient}
+}
+
+func (p *ClassifyProvider) Name() string {
+	return "classify"
+}
+
+func (p *ClassifyProvider) Find(id string) (BookMetadata, error) {
+	var c ClassifyBookResponse
+	body, err := p.classifyAPI(url.Values{"owi": {id}})
+
+	if err != nil {
+		return BookMetadata{}, err
+	}
+
+	if err = xml.Unmarshal(body, &c); err != nil {
+		return BookMetadata{}, err
+	}
+	return BookMetadata{
+		Title:          c.BookData.Title,
+		Author:         c.BookData.Author,
+		Classification: c.Classification.MostPopular,
+		ID:             c.BookData.ID,
+		Year:           atoi(c.BookData.FirstYear),
+	}, nil
+}
+
+func (p *ClassifyProvider) Search(q SearchQuery) ([]SearchResult, error) {
+	var c ClassifySearchResponse
+	params := url.Values{}
+	if q.Title != "" {
+		params.Set("title", q.Title)
+	}
+	if q.Author != "" {
+		params.Set("author", q.Author)
+	}
+	if q.ISBN != "" {
+		params.Set("isbn", q.ISBN)
+	}
+	body, err := p.classifyAPI(params)
+
+	if err != nil {
+		return []SearchResult{}, err
+	}
+
+	if err = xml.Unmarshal(body, &c); err != nil {
+		return []SearchResult{}, err
+	}
+	if len(c.Results) == 0 && c.Work.ID != "" {
+		return []SearchResult{c.Work}, nil
+	}
+	return c.Results, nil
+}
+
+// classifyResponseCode is the status Classify reports inside every response
+type classifyResponseCode struct {
+	Response struct {
+		Code string `xml:"code,attr"`
+	} `xml:"response"`
+}
+
+const classifyNotFound = "102"
+
+func (p *ClassifyProvider) classifyAPI(params url.Values) ([]byte, error) {
+	var resp *http.Response
+	var err error
+
+	params.Set("summary", "true")
+	key := cacheKey("classify", params)
+	if p.Cache != nil {
+		if body, _, ok := p.Cache.get(key); ok {
+			return body, nil
+		}
+	}
+
+	if resp, err = p.Client.Get(p.BaseURL + "?" + params.Encode()); err != nil {
+		return []byte{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return []byte{}, errors.New("classify: " + resp.Status)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil || p.Cache == nil {
+		return body, err
+	}
+
+	//  cache works and "not found", but not errors such as invalid input
+	var code classifyResponseCode
+	if xml.Unmarshal(body, &code) == nil {
+		switch code.Response.Code {
+		case "0", "2", "4":
+			p.Cache.set(key, body, false)
+		case classifyNotFound:
+			p.Cache.set(key, body, true)
+		}
+	}
+	return body, nil
+}
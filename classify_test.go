@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// classifyStandIn serves canned Classify responses, keyed on the query it expects
+func classifyStandIn(t *testing.T, responses map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := responses[r.URL.RawQuery]
+		if !ok {
+			t.Errorf("unexpected query %q", r.URL.RawQuery)
+			http.Error(w, "unexpected query", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write([]byte(body))
+	}))
+}
+
+func TestClassifyFind(t *testing.T) {
+	server := classifyStandIn(t, map[string]string{
+		"owi=1234&summary=true": `<?xml version="1.0" encoding="UTF-8"?>
+<classify xmlns="http://classify.oclc.org">
+  <response code="0"/>
+  <work author="Herbert, Frank" hyr="2005" lyr="1965" owi="1234" title="Dune"/>
+  <recommendations>
+    <ddc><mostPopular holdings="100" nsfa="813.54" sfa="813.54"/></ddc>
+  </recommendations>
+</classify>`,
+	})
+	defer server.Close()
+
+	got, err := NewClassifyProvider(server.URL).Find("1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := BookMetadata{Title: "Dune", Author: "Herbert, Frank", Classification: "813.54", ID: "1234", Year: 1965}
+	if got != want {
+		t.Errorf("Find = %+v, want %+v", got, want)
+	}
+}
+
+func TestClassifySearch(t *testing.T) {
+	server := classifyStandIn(t, map[string]string{
+		"summary=true&title=dune": `<?xml version="1.0" encoding="UTF-8"?>
+<classify xmlns="http://classify.oclc.org">
+  <response code="4"/>
+  <works>
+    <work author="Herbert, Frank" hyr="2005" owi="1234" title="Dune"/>
+    <work author="Herbert, Brian" hyr="2001" owi="5678" title="Dune: House Atreides"/>
+  </works>
+</classify>`,
+		//  an ISBN identifies a single work, which Classify sends without the works list
+		"isbn=9780441013593&summary=true": `<?xml version="1.0" encoding="UTF-8"?>
+<classify xmlns="http://classify.oclc.org">
+  <response code="0"/>
+  <work author="Herbert, Frank" hyr="2005" owi="1234" title="Dune"/>
+</classify>`,
+		"summary=true&title=nothing": `<?xml version="1.0" encoding="UTF-8"?>
+<classify xmlns="http://classify.oclc.org">
+  <response code="102"/>
+</classify>`,
+	})
+	defer server.Close()
+	p := NewClassifyProvider(server.URL)
+
+	tests := []struct {
+		q    SearchQuery
+		want []SearchResult
+	}{
+		{SearchQuery{Title: "dune"}, []SearchResult{
+			{Title: "Dune", Author: "Herbert, Frank", Year: "2005", ID: "1234"},
+			{Title: "Dune: House Atreides", Author: "Herbert, Brian", Year: "2001", ID: "5678"},
+		}},
+		{SearchQuery{ISBN: "9780441013593"}, []SearchResult{
+			{Title: "Dune", Author: "Herbert, Frank", Year: "2005", ID: "1234"},
+		}},
+		{SearchQuery{Title: "nothing"}, nil},
+	}
+	for _, test := range tests {
+		got, err := p.Search(test.q)
+		if err != nil {
+			t.Errorf("Search(%+v): %s", test.q, err)
+			continue
+		}
+		if (len(got) > 0 || len(test.want) > 0) && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Search(%+v) = %+v, want %+v", test.q, got, test.want)
+		}
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	if _, err := NewClassifyProvider(server.URL).Find("1234"); err == nil {
+		t.Error("Find succeeded against a failing server")
+	}
+	if _, err := NewClassifyProvider(server.URL).Search(SearchQuery{Title: "dune"}); err == nil {
+		t.Error("Search succeeded against a failing server")
+	}
+}
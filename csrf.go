@@ -0,0 +1,62 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"strings"
+
+	"github.com/goincremental/negroni-sessions"
+	"github.com/gorilla/securecookie"
+)
+
+const (
+	csrfSessionKey = "CSRF"
+	csrfHeader     = "X-CSRF-Token" //set on every jQuery request, see templates
+	csrfFormField  = "csrf_token"   //for plain HTML forms
+)
+
+// csrfExemptions decide whether a request is authenticated by something other than the
+// session cookie, and so cannot be forged by another site. A browser only sends an
+// Authorization header it was told to, never one another origin made it send.
+var csrfExemptions = []func(r *http.Request) bool{
+	func(r *http.Request) bool {
+		return strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ")
+	},
+}
+
+// middleware rejecting state-changing requests that do not echo the session's CSRF token
+func verifyCSRF(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	switch r.Method {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+		next(w, r)
+		return
+	}
+	for _, exempt := range csrfExemptions {
+		if exempt(r) {
+			next(w, r)
+			return
+		}
+	}
+
+	expected := getStringFromSession(r, csrfSessionKey)
+	given := r.Header.Get(csrfHeader)
+	if given == "" {
+		given = r.PostFormValue(csrfFormField)
+	}
+	if expected == "" || subtle.ConstantTimeCompare([]byte(expected), []byte(given)) != 1 {
+		requestError(w, r, "CSRF token missing or invalid", http.StatusForbidden)
+		return
+	}
+	next(w, r)
+}
+
+// csrfToken returns the session's CSRF token for a page to embed, creating it on first use
+func csrfToken(r *http.Request) string {
+	token := getStringFromSession(r, csrfSessionKey)
+	if token == "" {
+		token = base64.RawURLEncoding.EncodeToString(securecookie.GenerateRandomKey(32))
+		sessions.GetSession(r).Set(csrfSessionKey, token)
+	}
+	return token
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// columns written by the export, in order; the import accepts them in any order
+var csvColumns = []string{"title", "author", "classification", "id", "isbn10", "isbn13", "year",
+	"publisher", "edition", "page_count", "language", "notes", "shelves", "rating", "date_read"}
+
+// other header names the import understands, mapped to a column in csvColumns.
+// "isbn" is special: it may hold either form and fills in both.
+var csvHeaderAliases = map[string]string{
+	"isbn":           "isbn",
+	"owi":            "id",
+	"oclc":           "id",
+	"oclc id":        "id",
+	"dewey":          "classification",
+	"ddc":            "classification",
+	"pages":          "page_count",
+	"page count":     "page_count",
+	"published":      "year",
+	"year published": "year",
+}
+
+// exportCSV streams the user's books as CSV with a header row
+func exportCSV(w http.ResponseWriter, username string) error {
+	rows, err := db.Query("select "+strings.Join(csvColumns, ", ")+" from books where \"user\"="+
+		dbmap.Dialect.BindVar(0)+" order by pk", username)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="books.csv"`)
+	out := csv.NewWriter(w)
+	out.Write(csvColumns)
+
+	//  scan through NullString, rows from before the first migration may hold NULLs
+	values := make([]sql.NullString, len(csvColumns))
+	dest := make([]interface{}, len(csvColumns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	record := make([]string, len(csvColumns))
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		for i, v := range values {
+			record[i] = v.String
+		}
+		if err := out.Write(record); err != nil {
+			return err
+		}
+		out.Flush()
+	}
+	out.Flush()
+	if err := out.Error(); err != nil {
+		return err
+	}
+	return rows.Err()
+}
+
+// csvHeaderMapping maps each column index of the file to the Book column it fills, "" for ignored columns
+func csvHeaderMapping(header []string) ([]string, error) {
+	known := map[string]bool{}
+	for _, col := range csvColumns {
+		known[col] = true
+	}
+
+	mapping := make([]string, len(header))
+	hasTitle := false
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if alias, ok := csvHeaderAliases[name]; ok {
+			name = alias
+		}
+		if known[name] || name == "isbn" {
+			mapping[i] = name
+		}
+		hasTitle = hasTitle || name == "title"
+	}
+	if !hasTitle {
+		return nil, errors.New("the header row must contain a title column")
+	}
+	return mapping, nil
+}
+
+// bookFromRecord fills a Book from one CSV record using the header mapping
+func bookFromRecord(record []string, mapping []string) (Book, error) {
+	var b Book
+	for i, value := range record {
+		if i >= len(mapping) {
+			break
+		}
+		value = strings.TrimSpace(value)
+		var err error
+		switch mapping[i] {
+		case "title":
+			b.Title = value
+		case "author":
+			b.Author = value
+		case "classification":
+			b.Classification = value
+		case "id":
+			b.ID = value
+		case "isbn", "isbn10", "isbn13":
+			if value != "" {
+				b.ISBN10, b.ISBN13, err = parseISBN(value)
+			}
+		case "year":
+			if value != "" {
+				b.Year, err = strconv.Atoi(value)
+			}
+		case "publisher":
+			b.Publisher = value
+		case "edition":
+			b.Edition = value
+		case "page_count":
+			if value != "" {
+				b.PageCount, err = strconv.Atoi(value)
+			}
+		case "language":
+			b.Language = value
+		case "notes":
+			b.Notes = value
+		case "shelves":
+			b.Shelves = value
+		case "rating":
+			if value != "" {
+				b.Rating, err = strconv.ParseFloat(value, 64)
+			}
+		case "date_read":
+			b.DateRead, err = normalizeDate(value)
+		}
+		if err != nil {
+			return b, errors.New(mapping[i] + ": " + err.Error())
+		}
+	}
+
+	if b.Title == "" {
+		return b, errors.New("title is empty")
+	}
+	if !validClassification(b.Classification) {
+		return b, errors.New("classification is not a Dewey number: " + b.Classification)
+	}
+	return b, nil
+}
+
+// csvBookReader reads books from a CSV file in the export format, or with any of the header aliases
+func csvBookReader(r io.Reader) (bookReader, error) {
+	in := csv.NewReader(r)
+	in.FieldsPerRecord = -1
+
+	header, err := in.Read()
+	if err != nil {
+		return nil, err
+	}
+	mapping, err := csvHeaderMapping(header)
+	if err != nil {
+		return nil, err
+	}
+
+	row := 1
+	return func() (Book, int, error) {
+		row++
+		record, err := readRecord(in)
+		if err != nil {
+			return Book{}, row, err
+		}
+		b, err := bookFromRecord(record, mapping)
+		return b, row, err
+	}, nil
+}
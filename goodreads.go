@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// goodreadsBookReader reads the CSV file from Goodreads' "Export Library"
+func goodreadsBookReader(r io.Reader) (bookReader, error) {
+	in := csv.NewReader(r)
+	in.FieldsPerRecord = -1
+	in.LazyQuotes = true
+
+	header, err := in.Read()
+	if err != nil {
+		return nil, err
+	}
+	col := headerIndex(header)
+	if _, ok := col["title"]; !ok {
+		return nil, errors.New("not a Goodreads export: no Title column")
+	}
+
+	row := 1
+	return func() (Book, int, error) {
+		row++
+		record, err := readRecord(in)
+		if err != nil {
+			return Book{}, row, err
+		}
+		get := func(name string) string { return recordField(record, col, name) }
+
+		b := Book{
+			Title:     get("title"),
+			Author:    get("author"),
+			Publisher: get("publisher"),
+			Edition:   get("binding"),
+			Notes:     get("private notes"),
+			PageCount: atoi(get("number of pages")),
+			Year:      atoi(get("original publication year")),
+		}
+		if b.Year == 0 {
+			b.Year = atoi(get("year published"))
+		}
+		//  0 stars means not rated
+		b.Rating, _ = strconv.ParseFloat(get("my rating"), 64)
+
+		//  ISBNs are exported as ="0439554934" to keep spreadsheets from mangling them
+		for _, name := range []string{"isbn13", "isbn"} {
+			isbn := strings.Trim(get(name), `="`)
+			if isbn10, isbn13, err := parseISBN(isbn); err == nil {
+				b.ISBN10, b.ISBN13 = isbn10, isbn13
+				break
+			}
+		}
+
+		//  the exclusive shelf (read, to-read, currently-reading) is not always repeated in Bookshelves
+		b.Shelves = joinShelves(append([]string{get("exclusive shelf")}, strings.Split(get("bookshelves"), ",")...))
+
+		if b.DateRead, err = normalizeDate(get("date read")); err != nil {
+			return b, row, err
+		}
+		if b.Title == "" {
+			return b, row, errors.New("title is empty")
+		}
+		return b, row, nil
+	}, nil
+}
+
+// headerIndex maps lower-cased, trimmed column names to their position
+func headerIndex(header []string) map[string]int {
+	col := map[string]int{}
+	for i, name := range header {
+		col[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	return col
+}
+
+func recordField(record []string, col map[string]int, name string) string {
+	if i, ok := col[name]; ok && i < len(record) {
+		return strings.TrimSpace(record[i])
+	}
+	return ""
+}
+
+// joinShelves stores shelves, tags or collections as one comma separated list without duplicates
+func joinShelves(shelves []string) string {
+	var unique []string
+	seen := map[string]bool{}
+	for _, shelf := range shelves {
+		shelf = strings.TrimSpace(shelf)
+		if shelf != "" && !seen[shelf] {
+			seen[shelf] = true
+			unique = append(unique, shelf)
+		}
+	}
+	return strings.Join(unique, ", ")
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// readBooks drains next, returning the books it read and the rows it skipped
+func readBooks(t *testing.T, next bookReader) ([]Book, []ImportRowError) {
+	var books []Book
+	var skipped []ImportRowError
+	for {
+		b, row, err := next()
+		if err == io.EOF {
+			return books, skipped
+		} else if _, ok := err.(readError); ok {
+			t.Fatalf("row %d: %s", row, err)
+		} else if err != nil {
+			skipped = append(skipped, ImportRowError{row, err.Error()})
+			continue
+		}
+		books = append(books, b)
+	}
+}
+
+const goodreadsExport = "\ufeff" + `Book Id,Title,Author,ISBN,ISBN13,My Rating,Publisher,Binding,Number of Pages,Year Published,Original Publication Year,Date Read,Bookshelves,Exclusive Shelf,Private Notes
+3,Harry Potter and the Sorcerer's Stone,J.K. Rowling,"=""0439554934""","=""9780439554930""",5,Scholastic,Paperback,309,2003,1997,2020/01/31,"fantasy, favorites",read,Gift from Ann
+5,Dune,Frank Herbert,"=""""","=""9780441013593""",0,Ace,Paperback,604,2005,,,sci-fi,to-read,
+7,Unknown ISBN,Someone,"=""123""","=""""",3,,,,1990,,,,currently-reading,
+9,,Nobody,"=""""","=""""",0,,,,,,,,to-read,
+11,Bad Date,Someone,"=""""","=""""",0,,,,,,31 February 2020,,read,
+`
+
+func TestGoodreadsBookReader(t *testing.T) {
+	next, err := goodreadsBookReader(strings.NewReader(goodreadsExport))
+	if err != nil {
+		t.Fatal(err)
+	}
+	books, skipped := readBooks(t, next)
+
+	want := []Book{
+		{
+			Title: "Harry Potter and the Sorcerer's Stone", Author: "J.K. Rowling",
+			ISBN10: "0439554934", ISBN13: "9780439554930", Rating: 5,
+			Publisher: "Scholastic", Edition: "Paperback", PageCount: 309,
+			//  the original publication year wins over that of the edition
+			Year: 1997, DateRead: "2020-01-31", Shelves: "read, fantasy, favorites", Notes: "Gift from Ann",
+		},
+		{
+			//  an empty ISBN column does not stop the ISBN13 one being used
+			Title: "Dune", Author: "Frank Herbert", ISBN10: "0441013597", ISBN13: "9780441013593",
+			Publisher: "Ace", Edition: "Paperback", PageCount: 604, Year: 2005, Shelves: "to-read, sci-fi",
+		},
+		{
+			//  an ISBN that does not check out is dropped, not an error
+			Title: "Unknown ISBN", Author: "Someone", Rating: 3, Year: 1990, Shelves: "currently-reading",
+		},
+	}
+	if !reflect.DeepEqual(books, want) {
+		t.Errorf("books:\n got %+v\nwant %+v", books, want)
+	}
+	//  the empty title and the unreadable date
+	var rows []int
+	for _, s := range skipped {
+		rows = append(rows, s.Row)
+	}
+	if !reflect.DeepEqual(rows, []int{5, 6}) {
+		t.Errorf("skipped %+v, want rows 5 and 6", skipped)
+	}
+}
+
+func TestGoodreadsBookReaderHeader(t *testing.T) {
+	for _, export := range []string{
+		"",
+		"Book Id,Author\n1,Frank Herbert\n",
+	} {
+		if _, err := goodreadsBookReader(strings.NewReader(export)); err == nil {
+			t.Errorf("goodreadsBookReader accepted %q", export)
+		}
+	}
+}
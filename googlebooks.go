@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const defaultGoogleBooksURL = "https://www.googleapis.com/books/v1"
+
+type googleBooksVolume struct {
+	ID         string `json:"id"`
+	VolumeInfo struct {
+		Title               string   `json:"title"`
+		Authors             []string `json:"authors"`
+		Publisher           string   `json:"publisher"`
+		PublishedDate       string   `json:"publishedDate"`
+		PageCount           int      `json:"pageCount"`
+		Language            string   `json:"language"`
+		IndustryIdentifiers []struct {
+			Type       string `json:"type"`
+			Identifier string `json:"identifier"`
+		} `json:"industryIdentifiers"`
+	} `json:"volumeInfo"`
+}
+
+type googleBooksSearchResponse struct {
+	Items []googleBooksVolume `json:"items"`
+}
+
+// GoogleBooksProvider talks to the Google Books volumes API. The API key is optional.
+type GoogleBooksProvider struct {
+	BaseURL string
+	APIKey  string
+	Client  *http.Client
+}
+
+func NewGoogleBooksProvider(baseURL string, apiKey string) *GoogleBooksProvider {
+	return &GoogleBooksProvider{BaseURL: baseURL, APIKey: apiKey, Client: providerClient}
+}
+
+func (p *GoogleBooksProvider) Name() string {
+	return "googlebooks"
+}
+
+func (p *GoogleBooksProvider) Search(q SearchQuery) ([]SearchResult, error) {
+	var terms []string
+	if q.Title != "" {
+		terms = append(terms, "intitle:"+q.Title)
+	}
+	if q.Author != "" {
+		terms = append(terms, "inauthor:"+q.Author)
+	}
+	if q.ISBN != "" {
+		terms = append(terms, "isbn:"+q.ISBN)
+	}
+
+	var c googleBooksSearchResponse
+	if err := p.getJSON("/volumes", url.Values{"q": {strings.Join(terms, " ")}}, &c); err != nil {
+		return []SearchResult{}, err
+	}
+
+	results := make([]SearchResult, 0, len(c.Items))
+	for _, v := range c.Items {
+		results = append(results, SearchResult{
+			Title:  v.VolumeInfo.Title,
+			Author: strings.Join(v.VolumeInfo.Authors, ", "),
+			Year:   googleBooksYear(v.VolumeInfo.PublishedDate),
+			ID:     v.ID,
+			ISBN:   v.isbn(),
+		})
+	}
+	return results, nil
+}
+
+// Find looks a volume up by its Google Books volume ID. Google Books has no Dewey data.
+func (p *GoogleBooksProvider) Find(id string) (BookMetadata, error) {
+	var v googleBooksVolume
+	if err := p.getJSON("/volumes/"+url.PathEscape(id), url.Values{}, &v); err != nil {
+		return BookMetadata{}, err
+	}
+	book := BookMetadata{
+		Title:     v.VolumeInfo.Title,
+		Author:    strings.Join(v.VolumeInfo.Authors, ", "),
+		ID:        v.ID,
+		Year:      atoi(googleBooksYear(v.VolumeInfo.PublishedDate)),
+		Publisher: v.VolumeInfo.Publisher,
+		PageCount: v.VolumeInfo.PageCount,
+		Language:  v.VolumeInfo.Language,
+	}
+	for _, ident := range v.VolumeInfo.IndustryIdentifiers {
+		switch ident.Type {
+		case "ISBN_10":
+			book.ISBN10 = ident.Identifier
+		case "ISBN_13":
+			book.ISBN13 = ident.Identifier
+		}
+	}
+	return book, nil
+}
+
+// prefer the ISBN-13 so results dedupe against other providers
+func (v googleBooksVolume) isbn() string {
+	var isbn string
+	for _, ident := range v.VolumeInfo.IndustryIdentifiers {
+		if ident.Type == "ISBN_13" {
+			return ident.Identifier
+		}
+		if ident.Type == "ISBN_10" {
+			isbn = ident.Identifier
+		}
+	}
+	return isbn
+}
+
+// publishedDate is one of YYYY, YYYY-MM or YYYY-MM-DD
+func googleBooksYear(date string) string {
+	if len(date) >= 4 {
+		return date[:4]
+	}
+	return date
+}
+
+func (p *GoogleBooksProvider) getJSON(path string, params url.Values, v interface{}) error {
+	if p.APIKey != "" {
+		params.Set("key", p.APIKey)
+	}
+	resp, err := p.Client.Get(p.BaseURL + path + "?" + params.Encode())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("googlebooks: " + resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
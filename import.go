@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"gopkg.in/gorp.v1"
+)
+
+// ImportResult is the JSON response of an import
+type ImportResult struct {
+	Imported int
+	Skipped  int //duplicates of books already in the library
+	Errors   []ImportRowError
+}
+
+type ImportRowError struct {
+	Row   int //1-based line or record number in the file, a header being line 1
+	Error string
+}
+
+// bookReader yields the books of an import file one at a time, along with the row they came from.
+// It returns io.EOF after the last book and a readError if the file cannot be read any further;
+// any other error only skips that row.
+type bookReader func() (Book, int, error)
+
+// readError is a failure to read the import file itself, as opposed to a malformed or invalid row
+type readError struct {
+	error
+}
+
+// readRecord reads the next record for a bookReader, telling read errors from malformed rows
+func readRecord(in *csv.Reader) ([]string, error) {
+	record, err := in.Read()
+	if _, malformed := err.(*csv.ParseError); err != nil && err != io.EOF && !malformed {
+		return nil, readError{err}
+	}
+	return record, err
+}
+
+// newBookReader picks the parser for an import format: csv (our own export), goodreads or librarything
+func newBookReader(format string, r io.Reader) (bookReader, error) {
+	switch format {
+	case "", "csv":
+		return csvBookReader(r)
+	case "goodreads":
+		return goodreadsBookReader(r)
+	case "librarything":
+		return libraryThingBookReader(r)
+	}
+	return nil, errors.New("unknown import format: " + format)
+}
+
+// importBooks adds the books read by next to the user's library in a single transaction.
+// Rows that fail to parse are reported and skipped, as are books the user already has,
+// matched by OCLC ID or ISBN. Database errors and errors reading the file abort the import.
+// Imported books without a classification are queued to be looked up in Classify.
+func importBooks(next bookReader, username string) (ImportResult, error) {
+	result := ImportResult{Errors: []ImportRowError{}}
+
+	tx, err := dbmap.Begin()
+	if err != nil {
+		return result, err
+	}
+	seen, err := existingBookKeys(tx, username)
+	if err != nil {
+		tx.Rollback()
+		return result, err
+	}
+
+	for {
+		b, row, err := next()
+		if err == io.EOF {
+			break
+		} else if read, ok := err.(readError); ok {
+			tx.Rollback()
+			return result, read.error
+		} else if err != nil {
+			result.Errors = append(result.Errors, ImportRowError{row, err.Error()})
+			continue
+		}
+		if seen.contains(b) {
+			result.Skipped++
+			continue
+		}
+
+		b.PK = -1
+		b.User = username
+		if err := tx.Insert(&b); err != nil {
+			tx.Rollback()
+			return result, err
+		}
+		if b.Classification == "" {
+			if err := enqueueJob(tx, "classify_book", classifyBookPayload{b.PK}); err != nil {
+				tx.Rollback()
+				return result, err
+			}
+		}
+		seen.add(b)
+		result.Imported++
+	}
+	return result, tx.Commit()
+}
+
+// bookKeys holds the identifiers used to recognise a book the user already has
+type bookKeys map[string]bool
+
+func (k bookKeys) add(b Book) {
+	for _, key := range []string{"id:" + b.ID, "isbn:" + b.ISBN13, "isbn:" + b.ISBN10} {
+		if !strings.HasSuffix(key, ":") {
+			k[key] = true
+		}
+	}
+}
+
+func (k bookKeys) contains(b Book) bool {
+	return (b.ID != "" && k["id:"+b.ID]) || (b.ISBN13 != "" && k["isbn:"+b.ISBN13]) ||
+		(b.ISBN10 != "" && k["isbn:"+b.ISBN10])
+}
+
+func existingBookKeys(tx gorp.SqlExecutor, username string) (bookKeys, error) {
+	var books []Book
+	if _, err := tx.Select(&books, "select * from books where \"user\"="+dbmap.Dialect.BindVar(0), username); err != nil {
+		return nil, err
+	}
+	keys := bookKeys{}
+	for _, b := range books {
+		keys.add(b)
+	}
+	return keys, nil
+}
+
+// normalizeDate turns the date formats found in exports into YYYY-MM-DD, leaving "" alone
+func normalizeDate(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", nil
+	}
+	for _, layout := range []string{"2006-01-02", "2006/01/02", "2006/1/2", "Jan 2, 2006", "2 Jan 2006"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.Format("2006-01-02"), nil
+		}
+	}
+	return "", errors.New("unrecognised date: " + s)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var errInvalidISBN = errors.New("not a valid ISBN-10 or ISBN-13")
+
+// parseISBN validates an ISBN in either form, ignoring hyphens and spaces, and returns both forms.
+// isbn10 is empty for ISBN-13s outside the 978 prefix, which have no ISBN-10.
+func parseISBN(s string) (isbn10 string, isbn13 string, err error) {
+	isbn := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(s))
+	switch {
+	case len(isbn) == 10 && validISBN10(isbn):
+		return isbn, isbn10To13(isbn), nil
+	case len(isbn) == 13 && validISBN13(isbn):
+		return isbn13To10(isbn), isbn, nil
+	}
+	return "", "", errInvalidISBN
+}
+
+// ISBN-10: nine digits and a check digit (0-9 or X) making the weighted sum divisible by 11
+func validISBN10(isbn string) bool {
+	sum := 0
+	for i, c := range isbn {
+		var d int
+		switch {
+		case c >= '0' && c <= '9':
+			d = int(c - '0')
+		case c == 'X' && i == 9:
+			d = 10
+		default:
+			return false
+		}
+		sum += (10 - i) * d
+	}
+	return sum%11 == 0
+}
+
+// ISBN-13: EAN-13 with alternating 1 and 3 weights, the check digit makes the sum divisible by 10
+func validISBN13(isbn string) bool {
+	for _, c := range isbn {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return isbn13CheckDigit(isbn[:12]) == isbn[12]
+}
+
+func isbn13CheckDigit(first12 string) byte {
+	sum := 0
+	for i, c := range first12 {
+		d := int(c - '0')
+		if i%2 == 1 {
+			d *= 3
+		}
+		sum += d
+	}
+	return byte('0' + (10-sum%10)%10)
+}
+
+func isbn10CheckDigit(first9 string) byte {
+	sum := 0
+	for i, c := range first9 {
+		sum += (10 - i) * int(c-'0')
+	}
+	check := (11 - sum%11) % 11
+	if check == 10 {
+		return 'X'
+	}
+	return strconv.Itoa(check)[0]
+}
+
+func isbn10To13(isbn10 string) string {
+	first12 := "978" + isbn10[:9]
+	return first12 + string(isbn13CheckDigit(first12))
+}
+
+func isbn13To10(isbn13 string) string {
+	if !strings.HasPrefix(isbn13, "978") {
+		return ""
+	}
+	first9 := isbn13[3:12]
+	return first9 + string(isbn10CheckDigit(first9))
+}
@@ -0,0 +1,72 @@
+package main
+
+import "testing"
+
+func TestParseISBN(t *testing.T) {
+	tests := []struct {
+		in             string
+		isbn10, isbn13 string
+	}{
+		{"0441013597", "0441013597", "9780441013593"},
+		{"0-441-01359-7", "0441013597", "9780441013593"},
+		{"9780441013593", "0441013597", "9780441013593"},
+		{"978-0-441-01359-3", "0441013597", "9780441013593"},
+		{"978 0 441 01359 3", "0441013597", "9780441013593"},
+		//  a check digit of 10 is written X, in either case
+		{"080442957X", "080442957X", "9780804429573"},
+		{"0-8044-2957-x", "080442957X", "9780804429573"},
+		{"9780804429573", "080442957X", "9780804429573"},
+		//  a check digit of 0
+		{"0306406152", "0306406152", "9780306406157"},
+		//  979 ISBN-13s have no ISBN-10
+		{"9791090636071", "", "9791090636071"},
+	}
+	for _, test := range tests {
+		isbn10, isbn13, err := parseISBN(test.in)
+		if err != nil {
+			t.Errorf("parseISBN(%q): %s", test.in, err)
+			continue
+		}
+		if isbn10 != test.isbn10 || isbn13 != test.isbn13 {
+			t.Errorf("parseISBN(%q) = %q, %q, want %q, %q", test.in, isbn10, isbn13, test.isbn10, test.isbn13)
+		}
+	}
+}
+
+func TestParseISBNInvalid(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"0441013596",     //wrong check digit
+		"9780441013594",  //wrong check digit
+		"0X41013597",     //X anywhere but the check digit
+		"978044101359X",  //X in an ISBN-13
+		"044101359",      //too short
+		"04410135977",    //between the two lengths
+		"97804410135931", //too long
+		"0441O13597",     //a letter O for a zero
+		"0441.01359.7",
+	} {
+		if isbn10, isbn13, err := parseISBN(in); err != errInvalidISBN {
+			t.Errorf("parseISBN(%q) = %q, %q, %v, want errInvalidISBN", in, isbn10, isbn13, err)
+		}
+	}
+}
+
+func TestISBNConversions(t *testing.T) {
+	pairs := []struct{ isbn10, isbn13 string }{
+		{"0441013597", "9780441013593"},
+		{"080442957X", "9780804429573"},
+		{"0306406152", "9780306406157"},
+	}
+	for _, p := range pairs {
+		if got := isbn10To13(p.isbn10); got != p.isbn13 {
+			t.Errorf("isbn10To13(%q) = %q, want %q", p.isbn10, got, p.isbn13)
+		}
+		if got := isbn13To10(p.isbn13); got != p.isbn10 {
+			t.Errorf("isbn13To10(%q) = %q, want %q", p.isbn13, got, p.isbn10)
+		}
+	}
+	if got := isbn13To10("9791090636071"); got != "" {
+		t.Errorf("isbn13To10 of a 979 ISBN = %q, want none", got)
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/gorp.v1"
+)
+
+// Job is a row of the jobs table: a unit of background work that survives restarts
+type Job struct {
+	ID        int64  `db:"id"`
+	Kind      string `db:"kind"`    //key into jobHandlers
+	Payload   string `db:"payload"` //JSON, decoded by the handler
+	Status    string `db:"status"`  //pending, running, done or failed
+	Attempts  int    `db:"attempts"`
+	RunAt     int64  `db:"run_at"` //unix seconds, not picked up before then
+	LastError string `db:"last_error"`
+	CreatedAt int64  `db:"created_at"`
+	UpdatedAt int64  `db:"updated_at"`
+}
+
+const (
+	jobMaxAttempts  = 8
+	jobBackoff      = 5 * time.Second //doubled after every failed attempt
+	jobMaxBackoff   = time.Hour
+	jobPollInterval = time.Second
+	//  a job running for longer than this belongs to a worker that died and is picked up again
+	jobStaleAfter = 10 * time.Minute
+)
+
+// jobHandlers run a job's payload; a returned error schedules a retry
+var jobHandlers = map[string]func(payload []byte) error{
+	"enrich_book":   enrichBookJob,
+	"classify_book": classifyBookJob,
+}
+
+// enqueueJob stores a job to be run as soon as a worker is free. Pass a transaction as
+// exec to enqueue it atomically with the rows it refers to.
+func enqueueJob(exec gorp.SqlExecutor, kind string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	return exec.Insert(&Job{Kind: kind, Payload: string(body), Status: "pending", RunAt: now, CreatedAt: now, UpdatedAt: now})
+}
+
+// startWorkers runs JOB_WORKERS (default 2) goroutines polling the jobs table
+func startWorkers() {
+	n, _ := strconv.Atoi(os.Getenv("JOB_WORKERS"))
+	if n <= 0 {
+		n = 2
+	}
+	for i := 0; i < n; i++ {
+		go func() {
+			for {
+				ran, err := runNextJob()
+				if err != nil {
+					log.Printf("job worker: %s", err)
+				}
+				if !ran {
+					time.Sleep(jobPollInterval)
+				}
+			}
+		}()
+	}
+}
+
+// runNextJob claims the next due job and runs it, reporting whether there was one
+func runNextJob() (bool, error) {
+	job, err := claimJob()
+	if job == nil || err != nil {
+		return false, err
+	}
+
+	handler, ok := jobHandlers[job.Kind]
+	if !ok {
+		job.Attempts = jobMaxAttempts
+		err = finishJob(job, errors.New("no handler for job kind "+job.Kind))
+	} else {
+		err = finishJob(job, handler([]byte(job.Payload)))
+	}
+	return true, err
+}
+
+// claimJob marks the oldest due job as running. The update only succeeds if the row is
+// unchanged since it was read, so two workers or dynos never run the same job.
+func claimJob() (*Job, error) {
+	now := time.Now()
+	var jobs []Job
+	_, err := dbmap.Select(&jobs, "select * from jobs where (status='pending' and run_at<="+dbmap.Dialect.BindVar(0)+
+		") or (status='running' and updated_at<"+dbmap.Dialect.BindVar(1)+") order by run_at limit 1",
+		now.Unix(), now.Add(-jobStaleAfter).Unix())
+	if err != nil || len(jobs) == 0 {
+		return nil, err
+	}
+
+	job := jobs[0]
+	res, err := dbmap.Exec("update jobs set status='running', updated_at="+dbmap.Dialect.BindVar(0)+
+		" where id="+dbmap.Dialect.BindVar(1)+" and status="+dbmap.Dialect.BindVar(2)+" and updated_at="+dbmap.Dialect.BindVar(3),
+		now.Unix(), job.ID, job.Status, job.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if claimed, _ := res.RowsAffected(); claimed == 0 {
+		return nil, nil
+	}
+	job.Status, job.UpdatedAt = "running", now.Unix()
+	return &job, nil
+}
+
+// finishJob records the outcome of a run, scheduling a retry with exponential backoff on failure
+func finishJob(job *Job, runErr error) error {
+	now := time.Now()
+	job.Attempts++
+	job.UpdatedAt = now.Unix()
+	if runErr == nil {
+		job.Status, job.LastError = "done", ""
+		_, err := dbmap.Update(job)
+		return err
+	}
+
+	log.Printf("job %d (%s) attempt %d: %s", job.ID, job.Kind, job.Attempts, runErr)
+	job.LastError = runErr.Error()
+	if job.Attempts >= jobMaxAttempts {
+		job.Status = "failed"
+		if handler, ok := jobFailureHandlers[job.Kind]; ok {
+			handler([]byte(job.Payload))
+		}
+	} else {
+		backoff := jobBackoff << uint(job.Attempts-1)
+		if backoff > jobMaxBackoff {
+			backoff = jobMaxBackoff
+		}
+		job.Status = "pending"
+		job.RunAt = now.Add(backoff).Unix()
+	}
+	_, err := dbmap.Update(job)
+	return err
+}
+
+// jobFailureHandlers run once a job has used up its attempts
+var jobFailureHandlers = map[string]func(payload []byte){
+	"enrich_book": enrichBookFailed,
+}
+
+// enrichBookPayload identifies a pending book and the provider work it was added from
+type enrichBookPayload struct {
+	PK     int64
+	Source string
+	ID     string
+}
+
+// enrichBookJob fills a pending book in from its metadata provider
+func enrichBookJob(payload []byte) error {
+	var p enrichBookPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return err
+	}
+	book, err := find(p.Source, p.ID)
+	if err != nil {
+		return err
+	}
+
+	//  only fill in what is still empty, in one statement, so that whatever the user edited while
+	//  the book was pending stays as they left it. A book deleted meanwhile matches no row.
+	fill := []struct {
+		Column string
+		Value  interface{}
+		Empty  string //SQL literal of the column's empty value
+	}{
+		{"title", book.Title, "''"},
+		{"author", book.Author, "''"},
+		{"classification", book.Classification, "''"},
+		{"isbn10", book.ISBN10, "''"},
+		{"isbn13", book.ISBN13, "''"},
+		{"year", book.Year, "0"},
+		{"publisher", book.Publisher, "''"},
+		{"edition", book.Edition, "''"},
+		{"page_count", book.PageCount, "0"},
+		{"language", book.Language, "''"},
+	}
+	if !validClassification(book.Classification) {
+		fill[2].Value = ""
+	}
+	set := []string{"status=''"}
+	var args []interface{}
+	for _, f := range fill {
+		if f.Value == "" || f.Value == 0 {
+			continue
+		}
+		set = append(set, f.Column+"=case when coalesce("+f.Column+", "+f.Empty+")="+f.Empty+
+			" then "+dbmap.Dialect.BindVar(len(args))+" else "+f.Column+" end")
+		args = append(args, f.Value)
+	}
+	args = append(args, p.PK)
+	_, err = dbmap.Exec("update books set "+strings.Join(set, ", ")+" where pk="+dbmap.Dialect.BindVar(len(args)-1), args...)
+	return err
+}
+
+// enrichBookFailed stops the UI waiting on a book whose metadata could not be fetched
+func enrichBookFailed(payload []byte) {
+	var p enrichBookPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return
+	}
+	if _, err := dbmap.Exec("update books set status='failed' where pk="+dbmap.Dialect.BindVar(0), p.PK); err != nil {
+		log.Printf("marking book %d failed: %s", p.PK, err)
+	}
+}
+
+// classifyBookPayload identifies an imported book without a Dewey number
+type classifyBookPayload struct {
+	PK int64
+}
+
+// classifyBookJob looks an imported book up in Classify, by ISBN when it has one and by title
+// and author otherwise, and stores the Dewey number and OCLC ID it finds
+func classifyBookJob(payload []byte) error {
+	var p classifyBookPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return err
+	}
+	classify := getProvider("classify")
+	obj, err := dbmap.Get(Book{}, p.PK)
+	if classify == nil || err != nil || obj == nil {
+		return err
+	}
+	b := obj.(*Book)
+
+	q := SearchQuery{Title: b.Title, Author: b.Author}
+	if b.ISBN13 != "" {
+		q = SearchQuery{ISBN: b.ISBN13}
+	} else if b.ISBN10 != "" {
+		q = SearchQuery{ISBN: b.ISBN10}
+	}
+	results, err := classify.Search(q)
+	if err != nil || len(results) == 0 {
+		//  not knowing the book is an answer, not a reason to retry
+		return err
+	}
+	book, err := classify.Find(results[0].ID)
+	if err != nil || book.Classification == "" || !validClassification(book.Classification) {
+		return err
+	}
+
+	//  only the two columns, and only if the user has not classified the book meanwhile, so that
+	//  edits made during the lookups stay. An OCLC ID that came with the import is kept.
+	_, err = dbmap.Exec("update books set classification="+dbmap.Dialect.BindVar(0)+
+		", id=case when coalesce(id, '')='' then "+dbmap.Dialect.BindVar(1)+" else id end"+
+		" where pk="+dbmap.Dialect.BindVar(2)+" and coalesce(classification, '')=''",
+		book.Classification, results[0].ID, p.PK)
+	return err
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// libraryThingBook is one entry of the LibraryThing JSON export. Several fields are
+// a string in some exports and a list or object in others, so they are decoded by hand.
+type libraryThingBook struct {
+	Title          string          `json:"title"`
+	PrimaryAuthor  string          `json:"primaryauthor"`
+	Date           json.RawMessage `json:"date"`
+	Publication    string          `json:"publication"`
+	Rating         json.RawMessage `json:"rating"`
+	Pages          json.RawMessage `json:"pages"`
+	ISBN           json.RawMessage `json:"isbn"`
+	DDC            json.RawMessage `json:"ddc"`
+	Language       json.RawMessage `json:"language"`
+	Tags           json.RawMessage `json:"tags"`
+	Collections    json.RawMessage `json:"collections"`
+	DateFinished   json.RawMessage `json:"datefinished"`
+	PrivateComment string          `json:"privatecomment"`
+}
+
+// first number in free text such as "309 p." or "c1997"
+var firstNumber = regexp.MustCompile(`[0-9]+(\.[0-9]+)?`)
+
+// libraryThingBookReader reads either LibraryThing export: JSON, or tab-separated text
+func libraryThingBookReader(r io.Reader) (bookReader, error) {
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	body = decodeUTF16(body)
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '{' {
+		return libraryThingJSONReader(trimmed)
+	}
+	return libraryThingTSVReader(bytes.NewReader(body))
+}
+
+func libraryThingJSONReader(body []byte) (bookReader, error) {
+	var books map[string]libraryThingBook
+	if err := json.Unmarshal(body, &books); err != nil {
+		return nil, err
+	}
+	//  records are keyed by LibraryThing book id, keep them in the order they were entered
+	ids := make([]string, 0, len(books))
+	for id := range books {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, _ := strconv.Atoi(ids[i])
+		b, _ := strconv.Atoi(ids[j])
+		return a < b
+	})
+
+	i := 0
+	return func() (Book, int, error) {
+		if i >= len(ids) {
+			return Book{}, i + 1, io.EOF
+		}
+		lt := books[ids[i]]
+		i++
+
+		b := Book{
+			Title:     strings.TrimSpace(lt.Title),
+			Author:    strings.TrimSpace(lt.PrimaryAuthor),
+			Publisher: libraryThingPublisher(lt.Publication),
+			Year:      atoi(firstNumber.FindString(first(jsonStrings(lt.Date)))),
+			PageCount: atoi(firstNumber.FindString(first(jsonStrings(lt.Pages)))),
+			Language:  first(jsonStrings(lt.Language)),
+			Shelves:   joinShelves(append(jsonStrings(lt.Collections), jsonStrings(lt.Tags)...)),
+			Notes:     lt.PrivateComment,
+		}
+		b.Rating, _ = strconv.ParseFloat(first(jsonStrings(lt.Rating)), 64)
+		for _, isbn := range jsonStrings(lt.ISBN) {
+			if isbn10, isbn13, err := parseISBN(isbn); err == nil {
+				b.ISBN10, b.ISBN13 = isbn10, isbn13
+				break
+			}
+		}
+		if ddc := first(jsonStrings(lt.DDC)); validClassification(ddc) {
+			b.Classification = ddc
+		}
+
+		var err error
+		if b.DateRead, err = normalizeDate(first(jsonStrings(lt.DateFinished))); err != nil {
+			return b, i, err
+		}
+		if b.Title == "" {
+			return b, i, errors.New("title is empty")
+		}
+		return b, i, nil
+	}, nil
+}
+
+func libraryThingTSVReader(r io.Reader) (bookReader, error) {
+	in := csv.NewReader(bufio.NewReader(r))
+	in.Comma = '\t'
+	in.FieldsPerRecord = -1
+	in.LazyQuotes = true
+
+	header, err := in.Read()
+	if err != nil {
+		return nil, err
+	}
+	col := headerIndex(header)
+	if _, ok := col["title"]; !ok {
+		return nil, errors.New("not a LibraryThing export: no Title column")
+	}
+
+	row := 1
+	return func() (Book, int, error) {
+		row++
+		record, err := readRecord(in)
+		if err != nil {
+			return Book{}, row, err
+		}
+		get := func(name string) string { return recordField(record, col, name) }
+
+		b := Book{
+			Title:     get("title"),
+			Author:    get("primary author"),
+			Publisher: libraryThingPublisher(get("publication")),
+			Year:      atoi(firstNumber.FindString(get("date"))),
+			PageCount: atoi(firstNumber.FindString(get("page count"))),
+			Language:  strings.TrimSpace(strings.Split(get("languages"), ",")[0]),
+			Shelves:   joinShelves(append(strings.Split(get("collections"), ","), strings.Split(get("tags"), ",")...)),
+			Notes:     get("private comment"),
+		}
+		b.Rating, _ = strconv.ParseFloat(get("rating"), 64)
+		//  ISBNs come bracketed and comma separated, e.g. [0439554934, 9780439554930]
+		for _, isbn := range strings.Split(strings.Trim(get("isbns")+","+get("isbn"), "[],"), ",") {
+			if isbn10, isbn13, err := parseISBN(strings.Trim(isbn, "[] ")); err == nil {
+				b.ISBN10, b.ISBN13 = isbn10, isbn13
+				break
+			}
+		}
+		if ddc := get("dewey decimal"); validClassification(ddc) {
+			b.Classification = ddc
+		}
+
+		if b.DateRead, err = normalizeDate(get("date read")); err != nil {
+			return b, row, err
+		}
+		if b.Title == "" {
+			return b, row, errors.New("title is empty")
+		}
+		return b, row, nil
+	}, nil
+}
+
+// "Scholastic (1999), Edition: 1st, Paperback, 309 pages" -> "Scholastic"
+func libraryThingPublisher(publication string) string {
+	if i := strings.IndexAny(publication, "(,"); i >= 0 {
+		publication = publication[:i]
+	}
+	return strings.TrimSpace(publication)
+}
+
+// jsonStrings flattens a JSON string, number, list or object into its string values
+func jsonStrings(raw json.RawMessage) []string {
+	if len(raw) == 0 {
+		return nil
+	}
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil
+	}
+	return flattenStrings(v)
+}
+
+func flattenStrings(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case float64:
+		return []string{strconv.FormatFloat(v, 'f', -1, 64)}
+	case []interface{}:
+		var out []string
+		for _, item := range v {
+			out = append(out, flattenStrings(item)...)
+		}
+		return out
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var out []string
+		for _, k := range keys {
+			out = append(out, flattenStrings(v[k])...)
+		}
+		return out
+	}
+	return nil
+}
+
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return strings.TrimSpace(values[0])
+}
+
+// decodeUTF16 converts a UTF-16 file with a byte order mark to UTF-8, as LibraryThing
+// writes its tab-separated export; anything else is returned without a UTF-8 BOM
+func decodeUTF16(body []byte) []byte {
+	var bigEndian bool
+	switch {
+	case bytes.HasPrefix(body, []byte{0xff, 0xfe}):
+		bigEndian = false
+	case bytes.HasPrefix(body, []byte{0xfe, 0xff}):
+		bigEndian = true
+	default:
+		return bytes.TrimPrefix(body, []byte("\ufeff"))
+	}
+
+	body = body[2:]
+	units := make([]uint16, len(body)/2)
+	for i := range units {
+		if bigEndian {
+			units[i] = uint16(body[2*i])<<8 | uint16(body[2*i+1])
+		} else {
+			units[i] = uint16(body[2*i+1])<<8 | uint16(body[2*i])
+		}
+	}
+	return []byte(string(utf16.Decode(units)))
+}
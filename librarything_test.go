@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+// the LibraryThing JSON export, where several fields are strings in some records and
+// lists or objects in others
+const libraryThingJSONExport = `{
+  "10": {
+    "title": "Dune",
+    "primaryauthor": "Herbert, Frank",
+    "date": 1965,
+    "publication": "Ace (2005), Edition: Reissue, Paperback, 604 pages",
+    "pages": "604 ",
+    "isbn": ["9780441013593"],
+    "ddc": "813.54",
+    "language": "English",
+    "tags": "sci-fi",
+    "rating": 4.5
+  },
+  "2": {
+    "title": "Harry Potter and the Sorcerer's Stone",
+    "primaryauthor": "Rowling, J.K.",
+    "date": "c1997",
+    "publication": "Scholastic (1999)",
+    "pages": "309",
+    "isbn": {"0": "0439554934", "2": "9780439554930"},
+    "ddc": {"code": ["823.914"], "wording": ["Literature"]},
+    "language": ["English", "Latin"],
+    "tags": ["fantasy", "favorites"],
+    "collections": ["Your library", "favorites"],
+    "datefinished": ["2020-01-31"],
+    "privatecomment": "Gift from Ann"
+  },
+  "31": {
+    "title": "No Dewey",
+    "ddc": "Fic"
+  },
+  "40": {
+    "title": " ",
+    "primaryauthor": "Nobody"
+  }
+}`
+
+func TestLibraryThingJSON(t *testing.T) {
+	next, err := libraryThingBookReader(strings.NewReader(libraryThingJSONExport))
+	if err != nil {
+		t.Fatal(err)
+	}
+	books, skipped := readBooks(t, next)
+
+	//  in the order the books were entered, by id, not as the object lists them
+	want := []Book{
+		{
+			Title: "Harry Potter and the Sorcerer's Stone", Author: "Rowling, J.K.", Publisher: "Scholastic",
+			Year: 1997, PageCount: 309, Language: "English", Shelves: "Your library, favorites, fantasy",
+			Notes: "Gift from Ann", ISBN10: "0439554934", ISBN13: "9780439554930", Classification: "823.914",
+			DateRead: "2020-01-31",
+		},
+		{
+			Title: "Dune", Author: "Herbert, Frank", Publisher: "Ace", Year: 1965, PageCount: 604,
+			Language: "English", Shelves: "sci-fi", Rating: 4.5, ISBN10: "0441013597", ISBN13: "9780441013593",
+			Classification: "813.54",
+		},
+		//  a shelving code that is not a Dewey number is left out
+		{Title: "No Dewey"},
+	}
+	if !reflect.DeepEqual(books, want) {
+		t.Errorf("books:\n got %+v\nwant %+v", books, want)
+	}
+	if len(skipped) != 1 || skipped[0].Row != 4 || skipped[0].Error != "title is empty" {
+		t.Errorf("skipped %+v, want record 4 for its empty title", skipped)
+	}
+}
+
+// the tab-separated LibraryThing export
+const libraryThingTSVExport = "Book Id\tTitle\tPrimary Author\tDate\tPublication\tPage Count\tLanguages\tISBNs\tDewey Decimal\tTags\tCollections\tRating\tDate Read\tPrivate Comment\r\n" +
+	"2\tHarry Potter and the Sorcerer's Stone\tRowling, J.K.\tc1997\tScholastic (1999), Paperback\t309 p.\tEnglish, Latin\t[0439554934, 9780439554930]\t823.914\tfantasy, favorites\tYour library\t5\t2020/01/31\tGift from Ann\r\n" +
+	"10\tDune\tHerbert, Frank\t1965\tAce (2005)\t604\tEnglish\t[9780441013593]\t\tsci-fi\tYour library\t\t\t\r\n" +
+	"11\t\tNobody\t\t\t\t\t\t\t\t\t\t\t\r\n"
+
+func TestLibraryThingTSV(t *testing.T) {
+	want := []Book{
+		{
+			Title: "Harry Potter and the Sorcerer's Stone", Author: "Rowling, J.K.", Publisher: "Scholastic",
+			Year: 1997, PageCount: 309, Language: "English", Shelves: "Your library, fantasy, favorites",
+			Rating: 5, Notes: "Gift from Ann", ISBN10: "0439554934", ISBN13: "9780439554930",
+			Classification: "823.914", DateRead: "2020-01-31",
+		},
+		{
+			Title: "Dune", Author: "Herbert, Frank", Publisher: "Ace", Year: 1965, PageCount: 604,
+			Language: "English", Shelves: "Your library, sci-fi", ISBN10: "0441013597", ISBN13: "9780441013593",
+		},
+	}
+
+	//  LibraryThing writes this export in UTF-16, either byte order, with a byte order mark
+	for _, test := range []struct {
+		encoding string
+		export   []byte
+	}{
+		{"UTF-8", []byte(libraryThingTSVExport)},
+		{"UTF-8 with a BOM", []byte("\ufeff" + libraryThingTSVExport)},
+		{"UTF-16LE", encodeUTF16(libraryThingTSVExport, false)},
+		{"UTF-16BE", encodeUTF16(libraryThingTSVExport, true)},
+	} {
+		next, err := libraryThingBookReader(strings.NewReader(string(test.export)))
+		if err != nil {
+			t.Errorf("%s: %s", test.encoding, err)
+			continue
+		}
+		books, skipped := readBooks(t, next)
+		if !reflect.DeepEqual(books, want) {
+			t.Errorf("%s: books:\n got %+v\nwant %+v", test.encoding, books, want)
+		}
+		if len(skipped) != 1 || skipped[0].Row != 4 {
+			t.Errorf("%s: skipped %+v, want row 4 for its empty title", test.encoding, skipped)
+		}
+	}
+}
+
+// encodeUTF16 is s in UTF-16 with a byte order mark
+func encodeUTF16(s string, bigEndian bool) []byte {
+	var out []byte
+	for _, u := range utf16.Encode([]rune("\ufeff" + s)) {
+		if bigEndian {
+			out = append(out, byte(u>>8), byte(u))
+		} else {
+			out = append(out, byte(u), byte(u>>8))
+		}
+	}
+	return out
+}
+
+func TestDecodeUTF16(t *testing.T) {
+	tests := []struct {
+		in   []byte
+		want string
+	}{
+		{[]byte("plain"), "plain"},
+		{[]byte("\ufeffwith a BOM"), "with a BOM"},
+		{encodeUTF16("Łódź 📚", false), "Łódź 📚"},
+		{encodeUTF16("Łódź 📚", true), "Łódź 📚"},
+		{[]byte{0xff, 0xfe}, ""},
+	}
+	for _, test := range tests {
+		if got := string(decodeUTF16(test.in)); got != test.want {
+			t.Errorf("decodeUTF16(% x) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestJSONStrings(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want []string
+	}{
+		{``, nil},
+		{`null`, nil},
+		{`"823.914"`, []string{"823.914"}},
+		{`1965`, []string{"1965"}},
+		{`4.5`, []string{"4.5"}},
+		{`["English", "Latin"]`, []string{"English", "Latin"}},
+		//  objects by key, nested values flattened in place
+		{`{"2": "9780439554930", "0": "0439554934"}`, []string{"0439554934", "9780439554930"}},
+		{`{"code": ["823.914"], "wording": ["Literature"]}`, []string{"823.914", "Literature"}},
+		{`[["a", "b"], {"k": "c"}, 1]`, []string{"a", "b", "c", "1"}},
+		{`true`, nil},
+		{`{not json`, nil},
+	}
+	for _, test := range tests {
+		if got := jsonStrings(json.RawMessage(test.raw)); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("jsonStrings(%s) = %q, want %q", test.raw, got, test.want)
+		}
+	}
+}
+
+func TestLibraryThingPublisher(t *testing.T) {
+	for in, want := range map[string]string{
+		"Scholastic (1999), Edition: 1st, Paperback, 309 pages": "Scholastic",
+		"Ace, Paperback": "Ace",
+		"Penguin":        "Penguin",
+		"":               "",
+	} {
+		if got := libraryThingPublisher(in); got != want {
+			t.Errorf("libraryThingPublisher(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// LoginThrottle is a row of the login_throttle table: the recent failed logins for one
+// username or one client IP
+type LoginThrottle struct {
+	Key           string `db:"throttle_key"` //"user:" or "ip:" followed by the username or address
+	Failures      int    `db:"failures"`
+	LastFailureAt int64  `db:"last_failure_at"`
+	BlockedUntil  int64  `db:"blocked_until"` //no login attempt is checked before then
+}
+
+const (
+	//  failures allowed before attempts are slowed down
+	loginFreeFailures = 3
+	loginBackoff      = time.Second //doubled after every further failure
+	loginMaxBackoff   = 15 * time.Minute
+	//  failures after which a username is locked, whatever the IP
+	loginLockoutFailures = 10
+	loginLockout         = time.Hour
+	//  failures older than this are forgotten
+	loginFailureWindow = 24 * time.Hour
+)
+
+// the uniform answer to a wrong password and an unknown username alike
+const invalidCredentials = "Invalid username or password"
+
+// compared against when there is no such user, so both cases take as long
+var dummySecret, _ = bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
+
+// Until is when the block ends, for templates
+func (t LoginThrottle) Until() time.Time {
+	return time.Unix(t.BlockedUntil, 0)
+}
+
+func loginThrottleKeys(username string, ip string) []string {
+	return []string{"user:" + strings.ToLower(username), "ip:" + ip}
+}
+
+// loginBlockedFor returns how long until any of keys may try to log in again, 0 if it may now
+func loginBlockedFor(keys []string) (time.Duration, error) {
+	now := time.Now()
+	var wait time.Duration
+	for _, key := range keys {
+		obj, err := dbmap.Get(LoginThrottle{}, key)
+		if err != nil {
+			return 0, err
+		}
+		if obj == nil {
+			continue
+		}
+		if until := time.Unix(obj.(*LoginThrottle).BlockedUntil, 0); until.Sub(now) > wait {
+			wait = until.Sub(now)
+		}
+	}
+	return wait, nil
+}
+
+// recordLoginFailure counts a failed login against each of keys and blocks them for
+// exponentially longer, locking a username out for loginLockout after loginLockoutFailures
+func recordLoginFailure(keys []string) error {
+	now := time.Now()
+	for _, key := range keys {
+		obj, err := dbmap.Get(LoginThrottle{}, key)
+		if err != nil {
+			return err
+		}
+		t := &LoginThrottle{Key: key}
+		if obj != nil && now.Sub(time.Unix(obj.(*LoginThrottle).LastFailureAt, 0)) < loginFailureWindow {
+			t = obj.(*LoginThrottle)
+		}
+		t.Failures++
+		t.LastFailureAt = now.Unix()
+
+		var block time.Duration
+		if t.Failures > loginFreeFailures {
+			block = loginBackoff << uint(t.Failures-loginFreeFailures-1)
+			if block > loginMaxBackoff || block <= 0 {
+				block = loginMaxBackoff
+			}
+		}
+		if strings.HasPrefix(key, "user:") && t.Failures >= loginLockoutFailures {
+			block = loginLockout
+			log.Printf("locking out %s after %d failed logins", key, t.Failures)
+		}
+		t.BlockedUntil = now.Add(block).Unix()
+
+		if obj == nil {
+			err = dbmap.Insert(t)
+		} else {
+			_, err = dbmap.Exec("update login_throttle set failures="+dbmap.Dialect.BindVar(0)+", last_failure_at="+dbmap.Dialect.BindVar(1)+
+				", blocked_until="+dbmap.Dialect.BindVar(2)+" where throttle_key="+dbmap.Dialect.BindVar(3),
+				t.Failures, t.LastFailureAt, t.BlockedUntil, key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clearLoginFailures forgets the failures of keys, after a successful login or an admin unlock
+func clearLoginFailures(keys ...string) error {
+	for _, key := range keys {
+		if _, err := dbmap.Exec("delete from login_throttle where throttle_key="+dbmap.Dialect.BindVar(0), key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// blockedLogins lists the usernames and IPs currently kept from logging in, longest blocked first
+func blockedLogins() ([]LoginThrottle, error) {
+	var blocked []LoginThrottle
+	_, err := dbmap.Select(&blocked, "select * from login_throttle where blocked_until>"+dbmap.Dialect.BindVar(0)+
+		" order by blocked_until desc", time.Now().Unix())
+	return blocked, err
+}
+
+// isAdmin reports whether username is listed in ADMIN_USERS (comma separated). Usernames are
+// case sensitive, so the match is exact.
+func isAdmin(username string) bool {
+	for _, admin := range strings.Split(os.Getenv("ADMIN_USERS"), ",") {
+		if admin = strings.TrimSpace(admin); admin != "" && admin == username {
+			return true
+		}
+	}
+	return false
+}
+
+// purgeLoginThrottle deletes failures older than loginFailureWindow every interval
+func purgeLoginThrottle(interval time.Duration) {
+	for range time.Tick(interval) {
+		_, err := dbmap.Exec("delete from login_throttle where last_failure_at<="+dbmap.Dialect.BindVar(0)+
+			" and blocked_until<="+dbmap.Dialect.BindVar(1), time.Now().Add(-loginFailureWindow).Unix(), time.Now().Unix())
+		if err != nil {
+			log.Printf("purging login_throttle: %s", err)
+		}
+	}
+}
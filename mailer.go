@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Mailer sends a plain text email
+type Mailer interface {
+	Send(to string, subject string, body string) error
+}
+
+// mailer is the Mailer picked by MAILER, set by initMailer
+var mailer Mailer
+
+// siteURL is the site's address for links in emails, set by initMailer from BASE_URL. It never
+// comes from the request: its Host header is up to the client, who could have the links, and
+// the tokens in them, point to their own server.
+var siteURL string
+
+// initMailer sets up MAILER: "smtp" (SMTP_ADDR, SMTP_USERNAME, SMTP_PASSWORD), "file" (one
+// .eml file per message in MAIL_DIR) or "log", the default outside production. MAIL_FROM is
+// the sender. BASE_URL must be set in production.
+func initMailer() error {
+	production := os.Getenv("ENV") == "production"
+	siteURL = strings.TrimRight(os.Getenv("BASE_URL"), "/")
+	if siteURL == "" {
+		if production {
+			return errors.New("BASE_URL must be set in production")
+		}
+		siteURL = "http://localhost:" + getenvDefault("PORT", "8080")
+		log.Printf("BASE_URL is not set, linking to %s in emails", siteURL)
+	}
+
+	from := getenvDefault("MAIL_FROM", "no-reply@localhost")
+	switch kind := getenvDefault("MAILER", "log"); kind {
+	case "smtp":
+		addr := os.Getenv("SMTP_ADDR")
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return fmt.Errorf("SMTP_ADDR: %s", err)
+		}
+		m := &SMTPMailer{Addr: addr, From: from}
+		if username := os.Getenv("SMTP_USERNAME"); username != "" {
+			m.Auth = smtp.PlainAuth("", username, os.Getenv("SMTP_PASSWORD"), host)
+		}
+		mailer = m
+	case "file":
+		dir := getenvDefault("MAIL_DIR", "mail")
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+		mailer = &FileMailer{Dir: dir, From: from}
+	case "log":
+		//  the log would hold every reset and verification token
+		if production {
+			return errors.New("MAILER must be smtp or file in production")
+		}
+		mailer = &FileMailer{From: from}
+	default:
+		return fmt.Errorf("unknown MAILER %q", kind)
+	}
+	return nil
+}
+
+// SMTPMailer sends through an SMTP server, using STARTTLS when the server offers it
+type SMTPMailer struct {
+	Addr string
+	Auth smtp.Auth //nil to send unauthenticated
+	From string
+}
+
+func (m *SMTPMailer) Send(to string, subject string, body string) error {
+	return smtp.SendMail(m.Addr, m.Auth, m.From, []string{to}, formatMail(m.From, to, subject, body))
+}
+
+// FileMailer writes every message to a file in Dir, or to the log when Dir is empty,
+// for development and tests
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+func (m *FileMailer) Send(to string, subject string, body string) error {
+	msg := formatMail(m.From, to, subject, body)
+	if m.Dir == "" {
+		log.Printf("mail:\n%s", msg)
+		return nil
+	}
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, to))
+	return ioutil.WriteFile(filepath.Join(m.Dir, name), msg, 0600)
+}
+
+func formatMail(from string, to string, subject string, body string) []byte {
+	var msg bytes.Buffer
+	//  header values come from us and from validated addresses, but never let them span lines
+	header := strings.NewReplacer("\r", "", "\n", "")
+	fmt.Fprintf(&msg, "From: %s\r\n", header.Replace(from))
+	fmt.Fprintf(&msg, "To: %s\r\n", header.Replace(to))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", header.Replace(subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.Replace(body, "\n", "\r\n", -1))
+	return msg.Bytes()
+}
+
+// sendMailAsync sends without making the request wait for, or reveal, the outcome
+func sendMailAsync(to string, subject string, body string) {
+	go func() {
+		if err := mailer.Send(to, subject, body); err != nil {
+			log.Printf("mailing %s: %s", to, err)
+		}
+	}()
+}
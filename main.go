@@ -6,41 +6,67 @@ import (
 	_"github.com/lib/pq"
 	"gopkg.in/gorp.v1"
 	"net/http"
+	"net/mail"
 
 	"encoding/json"
-	"encoding/xml"
+	"expvar"
 	"golang.org/x/crypto/bcrypt"
+	"io"
+	"log"
 	"os"
-	"io/ioutil"
-	"net/url"
+	"regexp"
 	"strconv"
+	"strings"
+	"time"
 
 
 	"github.com/goincremental/negroni-sessions"
-	"github.com/goincremental/negroni-sessions/cookiestore"
 	gmux "github.com/gorilla/mux"
 	"github.com/urfave/negroni"
 	"github.com/yosssi/ace"
 )
 
 type Book struct {
-	PK             int64  `db:"pk"`
-	Title          string `db:"title"`
-	Author         string `db:"author"`
-	Classification string `db:"classification"`
-	ID             string `db:"id"`
-	User           string `db:"user"`
+	PK             int64   `db:"pk"`
+	Title          string  `db:"title"`
+	Author         string  `db:"author"`
+	Classification string  `db:"classification"`
+	ID             string  `db:"id"`
+	User           string  `db:"user"`
+	Notes          string  `db:"notes"`
+	ISBN10         string  `db:"isbn10"`
+	ISBN13         string  `db:"isbn13"`
+	Year           int     `db:"year"`
+	Publisher      string  `db:"publisher"`
+	Edition        string  `db:"edition"`
+	PageCount      int     `db:"page_count"`
+	Language       string  `db:"language"`
+	Shelves        string  `db:"shelves"` //comma separated, as imported from Goodreads or LibraryThing
+	Rating         float64 `db:"rating"`  //0 when unrated
+	DateRead       string  `db:"date_read"`
+	Status         string  `db:"status"` //"pending" while the metadata is being fetched, "failed" if that gave up
 }
 
 type User struct {
-	Username string `db:"username"`
-	Secret   []byte `db:"secret"`
+	Username           string `db:"username"`
+	Secret             []byte `db:"secret"`
+	Verified           int    `db:"verified"` //1 once the user opened the emailed link
+	VerificationSentAt int64  `db:"verification_sent_at"`
+	TOTPSecret         string `db:"totp_secret"`    //base32, "" without two-factor authentication
+	TOTPEnabled        int    `db:"totp_enabled"`   //1 once enrollment was confirmed with a code
+	TOTPLastStep       int64  `db:"totp_last_step"` //time step of the last code used, which cannot be used again
 }
 
 type Page struct {
-	Books  []Book
-	Filter string
-	User   string //let UI know which user logged in
+	Books      []Book
+	Filter     string
+	Sort       string
+	User       string //let UI know which user logged in
+	Unverified bool   //the user has not opened their verification link yet
+	Message    string
+	NextPage   string //URL of the books after these, "" when they are all shown
+	PageSize   int
+	CSRFToken  string
 }
 
 type SearchResult struct {
@@ -48,45 +74,41 @@ type SearchResult struct {
 	Author string `xml:"author,attr"`
 	Year   string `xml:"hyr,attr"`
 	ID     string `xml:"owi,attr"`
-}
-
-type ClassifySearchResponse struct {
-	Results []SearchResult `xml:"works>work"`
-}
-
-type ClassifyBookResponse struct {
-	BookData struct {
-		Title  string `xml:"title,attr"`
-		Author string `xml:"author,attr"`
-		ID     string `xml:"owi,attr"`
-	} `xml:"work"`
-	Classification struct {
-		MostPopular string `xml:"sfa,attr"`
-	} `xml:"recommendations>ddc>mostPopular"`
+	ISBN   string `xml:"-"`
+	Source string `xml:"-"` //name of the MetadataProvider the result came from
 }
 
 var db *sql.DB
 var dbmap *gorp.DbMap
 
+const sessionName = "go-for-web-dev"
+
+var sessionStore sessions.Store
+
 func initDb() {
 	if os.Getenv("ENV") != "production" {  //not in production mode, use sqlite
 		db, _ = sql.Open("sqlite3", "dev.db")
 		dbmap = &gorp.DbMap{Db: db, Dialect: gorp.SqliteDialect{}}
 	} else {  //use PostgreSQL for production mode
-		db, _  := sql.Open("postgres", os.Getenv("DATABASE_URL"))
+		db, _ = sql.Open("postgres", os.Getenv("DATABASE_URL"))
 		dbmap = &gorp.DbMap{Db: db, Dialect: gorp.PostgresDialect{}}
 	}
 
 
 	dbmap.AddTableWithName(Book{}, "books").SetKeys(true, "pk")
 	dbmap.AddTableWithName(User{}, "users").SetKeys(false, "username")
-	dbmap.CreateTablesIfNotExists()
+	dbmap.AddTableWithName(Job{}, "jobs").SetKeys(true, "id")
+	dbmap.AddTableWithName(SessionRecord{}, "sessions").SetKeys(false, "id")
+	dbmap.AddTableWithName(LoginThrottle{}, "login_throttle").SetKeys(false, "throttle_key")
+	dbmap.AddTableWithName(PasswordReset{}, "password_resets").SetKeys(false, "token_hash")
+	dbmap.AddTableWithName(APIToken{}, "api_tokens").SetKeys(true, "id")
+	dbmap.AddTableWithName(UserPreferences{}, "user_preferences").SetKeys(false, "username")
 }
 
 //  middleware to check database
 func verifyDatabase(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
 	if err := db.Ping(); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		requestError(w, r, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	next(w, r)
@@ -94,38 +116,103 @@ func verifyDatabase(w http.ResponseWriter, r *http.Request, next http.HandlerFun
 
 //  middleware to check user session is always set before allowing users to enter main page
 func verifyUser(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
-	if r.URL.Path == "/login" { // avoid redirect loop
+	switch r.URL.Path {
+	case "/login", "/login/2fa", "/forgot", "/reset", "/verify", "/api/openapi.json": // avoid redirect loop, and let users who cannot log in recover
 		next(w, r)
 		return
 	}
+	if r.Header.Get("Authorization") != "" {
+		verifyToken(w, r, next)
+		return
+	}
 	if username := getStringFromSession(r, "User"); username != "" {
 		if user, _ := dbmap.Get(User{}, username); user != nil {
+			if user.(*User).Verified == 0 && !allowedUnverified(r) {
+				requestError(w, r, "Please verify your email address first", http.StatusForbidden)
+				return
+			}
+			touchSession(r)
 			next(w, r)
 			return
 		}
 	}
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		writeAPIError(w, http.StatusUnauthorized, "log in or send an API token")
+		return
+	}
 	http.Redirect(w, r, "/login", http.StatusTemporaryRedirect)
 }
 
 //  implement initial sort
-func getBookCollections(books *[]Book, sortCol string, filterByClass string, username string, w http.ResponseWriter) bool {
-	if sortCol == "" {
-		sortCol = "pk" //set to default sorting by PK
+func getBookCollections(books *[]Book, keys []sortKey, filterByClass string, username string, page *bookPage, w http.ResponseWriter) bool {
+	if err := selectBooks(books, keys, filterByClass, username, page); err == errInvalidCursor {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+//  insert a book for the user from provider metadata, populating b
+func addBook(b *Book, book BookMetadata, username string, w http.ResponseWriter) bool {
+	*b = Book{
+		PK:             -1, //gorp will populate this value once the book is inserted into database
+		Title:          book.Title,
+		Author:         book.Author,
+		Classification: book.Classification,
+		ID:             book.ID,
+		User:           username,
+		ISBN10:         book.ISBN10,
+		ISBN13:         book.ISBN13,
+		Year:           book.Year,
+		Publisher:      book.Publisher,
+		Edition:        book.Edition,
+		PageCount:      book.PageCount,
+		Language:       book.Language,
 	}
-	where := " where \"user\"=" + dbmap.Dialect.BindVar(0)
-	if filterByClass == "fiction" {
-		where += " and classification between '800' and '900'"
-	} else if filterByClass == "nonfiction" {
-		where += " and classification not between '800' and '900'"
+	if err := dbmap.Insert(b); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
 	}
+	return true
+}
 
-	if _, err := dbmap.Select(books, "select * from books"+where+" order by "+sortCol, username); err != nil {
+//  load a single book, only if it belongs to the user
+func getOwnedBook(b *Book, pkVar string, username string, w http.ResponseWriter) bool {
+	pk, _ := strconv.ParseInt(pkVar, 10, 64)
+	q := "select * from books where pk=" + dbmap.Dialect.BindVar(0) + " and \"user\"=" + dbmap.Dialect.BindVar(1)
+	if err := dbmap.SelectOne(b, q, pk, username); err == sql.ErrNoRows {
+		//  another user's book is just as missing
+		http.Error(w, "No such book", http.StatusNotFound)
+		return false
+	} else if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return false
 	}
 	return true
 }
 
+//  Dewey Decimal numbers are three digits with an optional decimal part, e.g. 813.54
+var deweyPattern = regexp.MustCompile(`^[0-9]{3}(\.[0-9]+)?$`)
+
+//  an empty classification is allowed for books Classify knows nothing about
+func validClassification(c string) bool {
+	return c == "" || deweyPattern.MatchString(c)
+}
+
+// flashMessage pops the messages left for the next page, such as a redirect's outcome
+func flashMessage(r *http.Request) string {
+	var messages []string
+	for _, flash := range sessions.GetSession(r).Flashes() {
+		if s, ok := flash.(string); ok {
+			messages = append(messages, s)
+		}
+	}
+	return strings.Join(messages, " ")
+}
+
 func getStringFromSession(r *http.Request, key string) string {
 	var strVal string
 	//  get preference from session
@@ -136,39 +223,146 @@ func getStringFromSession(r *http.Request, key string) string {
 }
 
 type LoginPage struct {
-	Error string
+	Error     string
+	Message   string
+	CSRFToken string
+}
+
+type LockoutsPage struct {
+	User      string
+	Blocked   []LoginThrottle
+	CSRFToken string
+}
+
+type PasswordResetPage struct {
+	Token     string
+	Error     string
+	Message   string
+	CSRFToken string
+}
+
+type SettingsPage struct {
+	User      string
+	Error     string
+	Message   string
+	MinLength int
+	CSRFToken string
+}
+
+type TwoFactorPage struct {
+	User        string
+	Enabled     bool
+	Secret      string   //while enrolling, for apps that cannot scan the QR code
+	BackupCodes []string //only right after they were made
+	Error       string
+	Message     string
+	CSRFToken   string
+}
+
+type TokensPage struct {
+	User      string
+	Tokens    []APIToken
+	NewToken  string //only right after it was created
+	Error     string
+	CSRFToken string
+}
+
+type SessionsPage struct {
+	User      string
+	Sessions  []ActiveSession
+	CSRFToken string
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "bloom" {
+		if len(os.Args) != 4 {
+			log.Fatal("usage: bloom <password list> <filter file>")
+		}
+		if err := buildBloomFilter(os.Args[2], os.Args[3]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 	initDb()
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrateCommand(os.Args[2:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	//  bring the schema up to date before serving
+	if err := migrateUp(); err != nil {
+		log.Fatal(err)
+	}
+	initProvider()
+	if err := initPasswordPolicy(); err != nil {
+		log.Fatal(err)
+	}
+	if err := initMailer(); err != nil {
+		log.Fatal(err)
+	}
+	startWorkers()
 	mux := gmux.NewRouter()
 
 	//  login route
 	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
 		var p LoginPage
-		if r.FormValue("register") != "" {
-			secret, _ := bcrypt.GenerateFromPassword([]byte(r.FormValue("password")), bcrypt.DefaultCost)
-			user := User{r.FormValue("username"), secret}
-			if err := dbmap.Insert(&user); err != nil {
+		//  only a POST, which had to pass verifyCSRF, may register or log in
+		if r.PostFormValue("register") != "" {
+			user := User{Username: r.PostFormValue("username")}
+			var err error
+			//  the username is where verification links are sent, so a bare address and nothing else
+			if addr, perr := mail.ParseAddress(user.Username); perr != nil || addr.Address != user.Username {
+				p.Error = "Please register with your email address"
+			} else if err = policy.check(r.PostFormValue("password"), user.Username); err != nil {
+				p.Error = err.Error()
+			} else if user.Secret, err = hashPassword(r.PostFormValue("password")); err != nil {
 				p.Error = err.Error()
+			} else if err = dbmap.Insert(&user); err != nil {
+				log.Printf("registering %s: %s", user.Username, err)
+				p.Error = "Could not register that username"
 			} else { // register successfully
+				if err = sendVerificationEmail(user.Username); err != nil {
+					log.Printf("verifying %s: %s", user.Username, err)
+				}
 				sessions.GetSession(r).Set("User", user.Username)
 				http.Redirect(w, r, "/", http.StatusFound)
 				return
 			}
-		} else if r.FormValue("login") != "" {
-			user, err := dbmap.Get(User{}, r.FormValue("username"))
-			if err != nil {
+		} else if r.PostFormValue("login") != "" {
+			keys := loginThrottleKeys(r.PostFormValue("username"), clientIP(r))
+			if wait, err := loginBlockedFor(keys); err != nil {
+				p.Error = err.Error()
+			} else if wait > 0 {
+				seconds := int(wait/time.Second) + 1
+				w.Header().Set("Retry-After", strconv.Itoa(seconds))
+				w.WriteHeader(http.StatusTooManyRequests)
+				p.Error = "Too many failed attempts, try again in " + (time.Duration(seconds) * time.Second).String()
+			} else if user, err := dbmap.Get(User{}, r.PostFormValue("username")); err != nil {
 				p.Error = err.Error()
-			} else if user == nil {
-				p.Error = "No such user found with Username: " + r.FormValue("username")
 			} else {
-				//perform hard cast on the object returned from database
-				u := user.(*User)
-				if err = bcrypt.CompareHashAndPassword(u.Secret, []byte(r.FormValue("password"))); err != nil {
-					p.Error = err.Error()
+				//  an unknown user costs the same bcrypt comparison as a wrong password
+				secret := dummySecret
+				if user != nil {
+					//perform hard cast on the object returned from database
+					secret = user.(*User).Secret
+				}
+				if err = bcrypt.CompareHashAndPassword(secret, []byte(r.PostFormValue("password"))); err != nil || user == nil {
+					if err = recordLoginFailure(keys); err != nil {
+						log.Printf("recording failed login: %s", err)
+					}
+					p.Error = invalidCredentials
+				} else if rehashPassword(user.(*User), r.PostFormValue("password")); user.(*User).TOTPEnabled == 1 {
+					//  failures are only cleared once the second step passes too
+					sessions.GetSession(r).Set("TwoFactorUser", user.(*User).Username)
+					sessions.GetSession(r).Set("TwoFactorAt", time.Now().Unix())
+					http.Redirect(w, r, "/login/2fa", http.StatusFound)
+					return
 				} else { //authenticated successfully
-					sessions.GetSession(r).Set("User", u.Username)
+					if err = clearLoginFailures(keys...); err != nil {
+						log.Printf("clearing failed logins: %s", err)
+					}
+					sessions.GetSession(r).Set("User", user.(*User).Username)
 					http.Redirect(w, r, "/", http.StatusFound)
 					return
 				}
@@ -179,54 +373,254 @@ func main() {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		p.CSRFToken = csrfToken(r)
+		p.Message = flashMessage(r)
 		if err = template.Execute(w, p); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 	})
 
+	//  second login step for users with two-factor authentication
+	mux.HandleFunc("/login/2fa", func(w http.ResponseWriter, r *http.Request) {
+		session := sessions.GetSession(r)
+		username := getStringFromSession(r, "TwoFactorUser")
+		started, _ := session.Get("TwoFactorAt").(int64)
+		if username == "" || time.Since(time.Unix(started, 0)) > twoFactorLoginTTL {
+			session.Delete("TwoFactorUser")
+			session.Delete("TwoFactorAt")
+			session.AddFlash("Please log in again.")
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
+		}
+
+		var p LoginPage
+		if r.Method == "POST" {
+			keys := loginThrottleKeys(username, clientIP(r))
+			if wait, err := loginBlockedFor(keys); err != nil || wait > 0 {
+				p.Error = "Too many failed attempts, try again later"
+			} else if user, err := dbmap.Get(User{}, username); err != nil || user == nil {
+				http.Error(w, "No such user", http.StatusInternalServerError)
+				return
+			} else if ok, err := checkSecondFactor(user.(*User), r.PostFormValue("code")); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			} else if !ok {
+				if err = recordLoginFailure(keys); err != nil {
+					log.Printf("recording failed login: %s", err)
+				}
+				p.Error = "Invalid code"
+			} else { //authenticated successfully
+				if err = clearLoginFailures(keys...); err != nil {
+					log.Printf("clearing failed logins: %s", err)
+				}
+				session.Delete("TwoFactorUser")
+				session.Delete("TwoFactorAt")
+				session.Set("User", username)
+				http.Redirect(w, r, "/", http.StatusFound)
+				return
+			}
+		}
+		template, err := ace.Load("templates/login2fa", "", nil)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		p.CSRFToken = csrfToken(r)
+		if err = template.Execute(w, p); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}).Methods("GET", "POST")
+
+	//  forgot password: mail a reset link. The answer is the same whether or not the user exists.
+	mux.HandleFunc("/forgot", func(w http.ResponseWriter, r *http.Request) {
+		var p PasswordResetPage
+		if r.Method == "POST" {
+			username := r.PostFormValue("username")
+			user, err := dbmap.Get(User{}, username)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if user != nil {
+				token, err := createPasswordReset(username)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				if token != "" {
+					sendMailAsync(username, "Reset your password", "Someone asked to reset the password for "+username+
+						".\n\nTo choose a new password, open this link within an hour:\n\n"+
+						siteURL+"/reset?token="+token+"\n\nIf it was not you, ignore this email.\n")
+				}
+			}
+			p.Message = "If " + username + " has an account, a link to reset its password is on its way."
+		}
+		template, err := ace.Load("templates/forgot", "", nil)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		p.CSRFToken = csrfToken(r)
+		if err = template.Execute(w, p); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}).Methods("GET", "POST")
+
+	//  reset password from an emailed link
+	mux.HandleFunc("/reset", func(w http.ResponseWriter, r *http.Request) {
+		p := PasswordResetPage{Token: r.FormValue("token")}
+		if r.Method == "POST" {
+			username, err := checkPasswordReset(p.Token)
+			if err == errInvalidResetToken {
+				p.Token, p.Error = "", "This password reset link is invalid or has expired"
+			} else if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			} else if err = policy.check(r.PostFormValue("password"), username); err != nil {
+				p.Error = err.Error()
+			} else {
+				secret, err := hashPassword(r.PostFormValue("password"))
+				if err == nil {
+					_, err = resetPassword(p.Token, secret)
+				}
+				if err == errInvalidResetToken {
+					p.Token, p.Error = "", "This password reset link is invalid or has expired"
+				} else if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				} else {
+					//  whoever had the old password is logged out everywhere
+					if err = invalidateUserSessions(username, ""); err != nil {
+						log.Printf("ending sessions of %s: %s", username, err)
+					}
+					if err = clearLoginFailures("user:" + strings.ToLower(username)); err != nil {
+						log.Printf("clearing failed logins: %s", err)
+					}
+					p.Token, p.Message = "", "Your password has been changed."
+				}
+			}
+		} else if _, err := checkPasswordReset(p.Token); err == errInvalidResetToken {
+			p.Token, p.Error = "", "This password reset link is invalid or has expired"
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		template, err := ace.Load("templates/reset", "", nil)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		p.CSRFToken = csrfToken(r)
+		if err = template.Execute(w, p); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}).Methods("GET", "POST")
+
+	//  email verification link
+	mux.HandleFunc("/verify", func(w http.ResponseWriter, r *http.Request) {
+		if _, err := verifyEmail(r.FormValue("token")); err != nil {
+			log.Printf("verifying email: %s", err)
+			http.Error(w, "This verification link is invalid or has expired. Log in to get a new one.", http.StatusBadRequest)
+			return
+		}
+		sessions.GetSession(r).AddFlash("Your email address is verified.")
+		http.Redirect(w, r, "/", http.StatusFound)
+	}).Methods("GET")
+
+	//  send another verification link
+	mux.HandleFunc("/verify/resend", func(w http.ResponseWriter, r *http.Request) {
+		err := sendVerificationEmail(currentUser(r))
+		if err == errVerificationTooSoon {
+			http.Error(w, "A verification email was just sent, please wait a minute", http.StatusTooManyRequests)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}).Methods("POST")
+
 	//  logout route
 	mux.HandleFunc("/logout", func(w http.ResponseWriter, r *http.Request) {
 		sessions.GetSession(r).Set("User", nil)
-		sessions.GetSession(r).Set("Filter", nil) //clear filter preference
+		//  drop the stored session too, so it is gone from the devices page
+		sessions.GetSession(r).Options(sessions.Options{Path: "/", MaxAge: -1})
 
 		http.Redirect(w, r, "/login", http.StatusFound)
-	})
+	}).Methods("POST")
 
-	//  filter route
+	//  full-text search route
 	mux.HandleFunc("/books", func(w http.ResponseWriter, r *http.Request) {
-		var b []Book
-		//  pass default sort preference to sort
-		if !getBookCollections(&b, getStringFromSession(r, "sortBy"), r.FormValue("filter"),
-			getStringFromSession(r, "User"), w) {
+		hits := []BookHit{}
+		if !searchBooks(&hits, r.FormValue("q"), currentUser(r), w) {
 			return
 		}
 
-		//  store the sort preference in session
-		sessions.GetSession(r).Set("Filter", r.FormValue("filter"))
-		if err := json.NewEncoder(w).Encode(b); err != nil {
+		if err := json.NewEncoder(w).Encode(hits); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-	}).Methods("GET").Queries("filter", "{filter:all|fiction|nonfiction}")
+	}).Methods("GET").Queries("q", "{q}")
 
-	//  sort route
+	//  list route, filtered and sorted as asked or else as the user last did. What they
+	//  ask for is remembered as their preference.
 	mux.HandleFunc("/books", func(w http.ResponseWriter, r *http.Request) {
-		var b []Book
-		if !getBookCollections(&b, r.FormValue("sortBy"), getStringFromSession(r, "Filter"),
-			getStringFromSession(r, "User"), w) {
+		username := currentUser(r)
+		prefs := UserPreferences{Username: username}
+		var err error
+		//  scripts using a token neither follow nor change how the user lists their books
+		if !tokenAuthenticated(r) {
+			if prefs, err = userPreferences(username); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		saved := prefs
+
+		q := r.URL.Query()
+		if _, ok := q["filter"]; ok {
+			prefs.Filter = q.Get("filter")
+		}
+		if !validFilter(prefs.Filter) {
+			http.Error(w, "filter must be all, fiction or nonfiction", http.StatusBadRequest)
+			return
+		}
+		keys, msg := parseBookSort(prefs.Sort)
+		if _, ok := q["sort"]; ok {
+			keys, msg = parseBookSort(q.Get("sort"))
+			prefs.Sort = formatBookSort(keys)
+		}
+		if msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+		page, msg := parseBookPage(r, 0)
+		if msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
 			return
 		}
 
-		//  store the sort preference in session
-		sessions.GetSession(r).Set("SortBy", r.FormValue("sortBy"))
+		var b []Book
+		if !getBookCollections(&b, keys, prefs.Filter, username, &page, w) {
+			return
+		}
+		if prefs != saved && !tokenAuthenticated(r) {
+			if err := saveUserPreferences(prefs); err != nil {
+				log.Printf("saving preferences of %s: %s", username, err)
+			}
+		}
+		setPageLinks(w, r.URL.Path, prefs.query(), &page)
 		if err := json.NewEncoder(w).Encode(b); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-	}).Methods("GET").Queries("sortBy", "{sortBy:title|author|classification}")
+	}).Methods("GET")
 
 	//  root route
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
@@ -235,12 +629,25 @@ func main() {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
 
-		p := Page{Books: []Book{}, Filter: getStringFromSession(r, "Filter"), User: getStringFromSession(r, "User")}
-		//  sort the book collection by sorting preference from session
-		if !getBookCollections(&p.Books, getStringFromSession(r, "sortBy"), getStringFromSession(r, "Filter"),
-			p.User, w) {
+		p := Page{Books: []Book{}, User: currentUser(r), PageSize: bookPageSize, CSRFToken: csrfToken(r)}
+		if user, err := dbmap.Get(User{}, p.User); err == nil && user != nil {
+			p.Unverified = user.(*User).Verified == 0
+		}
+		p.Message = flashMessage(r)
+		prefs, err := userPreferences(p.User)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		p.Filter, p.Sort = prefs.Filter, prefs.Sort
+		//  sort the book collection by the user's preferences, the rest of it is loaded
+		//  as they scroll
+		page := bookPage{Limit: bookPageSize}
+		if !getBookCollections(&p.Books, prefs.sortKeys(), p.Filter,
+			p.User, &page, w) {
 			return
 		}
+		p.NextPage = pageLink("/books", prefs.query(), &page)
 
 		if err = template.Execute(w, p); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -253,8 +660,10 @@ func main() {
 		var results []SearchResult
 		var err error
 
-		if results, err = search(r.FormValue("search")); err != nil {
+		q := SearchQuery{Title: r.FormValue("search"), Author: r.FormValue("author"), ISBN: r.FormValue("isbn")}
+		if results, err = search(q); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
 
 		encoder := json.NewEncoder(w)
@@ -264,33 +673,68 @@ func main() {
 
 	}).Methods("POST")
 
-	//  add book route
+	//  add book by ISBN route
 	mux.HandleFunc("/books", func(w http.ResponseWriter, r *http.Request) {
-		var book ClassifyBookResponse
-		var err error
+		isbn10, isbn13, err := parseISBN(r.FormValue("isbn"))
+		if err != nil {
+			http.Error(w, r.FormValue("isbn")+": "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		book, err := findByISBN(isbn13)
+		if err == errNotFound {
+			http.Error(w, "no book found with ISBN "+isbn13, http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		//  the ISBN in hand identifies the edition better than whatever the provider picked
+		book.ISBN10, book.ISBN13 = isbn10, isbn13
 
-		if book, err = find(r.FormValue("id")); err != nil {
+		var b Book
+		if !addBook(&b, book, currentUser(r), w) {
+			return
+		}
+		if err := json.NewEncoder(w).Encode(b); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
+	}).Methods("PUT").Queries("isbn", "{isbn}")
+
+	//  add book route. The book is stored straight away as pending, with the title and author of
+	//  the search result when the UI sends them, and filled in from the provider by an enrich_book job
+	mux.HandleFunc("/books", func(w http.ResponseWriter, r *http.Request) {
+		source := r.FormValue("source")
+		if source == "" {
+			source = "classify"
+		}
+		if getProvider(source) == nil {
+			http.Error(w, "unknown metadata provider: "+source, http.StatusBadRequest)
+			return
+		}
 
-		//result, err := db.Exec("insert into books (pk, title, author, id, classification) values (?, ?, ?, ?, ?)",
-		//						nil, book.BookData.Title, book.BookData.Author, book.BookData.ID,
-		//							book.Classification.MostPopular)
-		//if err != nil {
-		//	http.Error(w, err.Error(), http.StatusInternalServerError)
-		//}
-		//
-		//pk, _ := result.LastInsertId()
 		b := Book{
-			PK:             -1, //gorp will populate this value once the book is inserted into database
-			Title:          book.BookData.Title,
-			Author:         book.BookData.Author,
-			Classification: book.Classification.MostPopular,
-			ID:             r.FormValue("id"),
-			User:           getStringFromSession(r, "User"),
+			PK:     -1,
+			Title:  r.FormValue("title"),
+			Author: r.FormValue("author"),
+			ID:     r.FormValue("id"),
+			User:   currentUser(r),
+			Status: "pending",
+		}
+		tx, err := dbmap.Begin()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
-		//insert and populate b
-		if dbmap.Insert(&b); err != nil {
+		if err = tx.Insert(&b); err == nil {
+			err = enqueueJob(tx, "enrich_book", enrichBookPayload{b.PK, source, b.ID})
+		}
+		if err == nil {
+			err = tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -300,68 +744,399 @@ func main() {
 		}
 	}).Methods("PUT")
 
+	//  CSV export route
+	mux.HandleFunc("/books/export.csv", func(w http.ResponseWriter, r *http.Request) {
+		if err := exportCSV(w, currentUser(r)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}).Methods("GET")
+
+	//  import route, accepting either a multipart "file" upload or the file as the body.
+	//  format is csv (the default), goodreads or librarything
+	mux.HandleFunc("/books/import", func(w http.ResponseWriter, r *http.Request) {
+		var in io.Reader = r.Body
+		if file, _, err := r.FormFile("file"); err == nil {
+			defer file.Close()
+			in = file
+		}
+
+		next, err := newBookReader(r.FormValue("format"), in)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		result, err := importBooks(next, currentUser(r))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := json.NewEncoder(w).Encode(result); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}).Methods("POST")
+
+	//  versioned JSON API
+	registerAPI(mux)
+
+	//  runtime counters, including the Classify cache hits and misses
+	mux.Handle("/debug/vars", expvar.Handler()).Methods("GET")
+
+	//  single book route, polled by the UI while a book is pending
+	mux.HandleFunc("/books/{pk:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		var b Book
+		if !getOwnedBook(&b, gmux.Vars(r)["pk"], currentUser(r), w) {
+			return
+		}
+		if err := json.NewEncoder(w).Encode(b); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}).Methods("GET")
+
+	//  edit book route
+	mux.HandleFunc("/books/{pk:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		var b Book
+		if !getOwnedBook(&b, gmux.Vars(r)["pk"], currentUser(r), w) {
+			return
+		}
+
+		//  only overwrite the fields present in the request
+		r.ParseForm()
+		if _, ok := r.Form["title"]; ok {
+			b.Title = strings.TrimSpace(r.FormValue("title"))
+		}
+		if _, ok := r.Form["author"]; ok {
+			b.Author = strings.TrimSpace(r.FormValue("author"))
+		}
+		if _, ok := r.Form["classification"]; ok {
+			b.Classification = strings.TrimSpace(r.FormValue("classification"))
+		}
+		if _, ok := r.Form["notes"]; ok {
+			b.Notes = r.FormValue("notes")
+		}
+
+		if b.Title == "" {
+			http.Error(w, "title must not be empty", http.StatusBadRequest)
+			return
+		}
+		if !validClassification(b.Classification) {
+			http.Error(w, "classification must be a Dewey number such as 813.54", http.StatusBadRequest)
+			return
+		}
+		if _, err := dbmap.Update(&b); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if err := json.NewEncoder(w).Encode(b); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}).Methods("PATCH")
+
 	//  delete book route
-	mux.HandleFunc("/books/{pk}", func(w http.ResponseWriter, r *http.Request) {
-		pk, _ := strconv.ParseInt(gmux.Vars(r)["pk"], 10, 64)
-		//only allowed to delete books that belong to the user
+	mux.HandleFunc("/books/{pk:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
 		var b Book
-		q := "select * from books where pk=" + dbmap.Dialect.BindVar(0) + " and \"user\"=" + dbmap.Dialect.BindVar(1)
-		if err := dbmap.SelectOne(&b, q, pk, getStringFromSession(r, "User")); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+		if !getOwnedBook(&b, gmux.Vars(r)["pk"], currentUser(r), w) {
+			return
 		}
 		if _, err := dbmap.Delete(&b); err != nil {
-
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
 
 		w.WriteHeader(http.StatusOK)
 	}).Methods("DELETE")
 
-	n := negroni.Classic()
-	n.Use(sessions.Sessions("go-for-web-dev", cookiestore.New([]byte("my-secret-123"))))
-	n.Use(negroni.HandlerFunc(verifyDatabase))
-	n.Use(negroni.HandlerFunc(verifyUser))
-	n.UseHandler(mux)
+	//  account settings
+	mux.HandleFunc("/settings", func(w http.ResponseWriter, r *http.Request) {
+		p := SettingsPage{User: currentUser(r), MinLength: policy.MinLength}
+		if r.Method == "POST" {
+			user, err := dbmap.Get(User{}, p.User)
+			if err != nil || user == nil {
+				http.Error(w, "No such user", http.StatusInternalServerError)
+				return
+			}
+			u := user.(*User)
+			password := r.PostFormValue("new_password")
+			//  a wrong current password counts as a failed login
+			keys := loginThrottleKeys(u.Username, clientIP(r))
+			if wait, err := loginBlockedFor(keys); err != nil || wait > 0 {
+				p.Error = "Too many failed attempts, try again later"
+			} else if bcrypt.CompareHashAndPassword(u.Secret, []byte(r.PostFormValue("current_password"))) != nil {
+				if err = recordLoginFailure(keys); err != nil {
+					log.Printf("recording failed login: %s", err)
+				}
+				p.Error = "Your current password is not correct"
+			} else if password != r.PostFormValue("confirm_password") {
+				p.Error = "The new passwords do not match"
+			} else if err = policy.check(password, u.Username); err != nil {
+				p.Error = err.Error()
+			} else {
+				secret, err := hashPassword(password)
+				if err == nil {
+					_, err = dbmap.Exec("update users set secret="+dbmap.Dialect.BindVar(0)+" where username="+dbmap.Dialect.BindVar(1),
+						secret, u.Username)
+				}
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				//  other devices have to log in with the new password
+				if err = invalidateUserSessions(u.Username, currentSessionID(r)); err != nil {
+					log.Printf("ending sessions of %s: %s", u.Username, err)
+				}
+				sessions.GetSession(r).AddFlash("Your password has been changed.")
+				http.Redirect(w, r, "/settings", http.StatusFound)
+				return
+			}
+		}
+		template, err := ace.Load("templates/settings", "", nil)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		p.CSRFToken = csrfToken(r)
+		p.Message = flashMessage(r)
+		if err = template.Execute(w, p); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}).Methods("GET", "POST")
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-	}
-	n.Run(":" + port)
+	//  two-factor authentication settings: enroll, make new backup codes or turn it off
+	mux.HandleFunc("/settings/2fa", func(w http.ResponseWriter, r *http.Request) {
+		session := sessions.GetSession(r)
+		user, err := dbmap.Get(User{}, currentUser(r))
+		if err != nil || user == nil {
+			http.Error(w, "No such user", http.StatusInternalServerError)
+			return
+		}
+		u := user.(*User)
+		p := TwoFactorPage{User: u.Username, Enabled: u.TOTPEnabled == 1}
 
-}
+		if r.Method == "POST" && !p.Enabled {
+			pending := getStringFromSession(r, "TOTPPending")
+			if step, ok := checkTOTP(pending, r.PostFormValue("code"), 0, time.Now()); pending == "" || !ok {
+				p.Error = "That code is not right, check your device's clock and try again"
+			} else if err = enableTOTP(u.Username, pending, step); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			} else if p.BackupCodes, err = newBackupCodes(u.Username); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			} else {
+				session.Delete("TOTPPending")
+				p.Enabled, p.Message = true, "Two-factor authentication is on."
+			}
+		} else if r.Method == "POST" {
+			if p.Error, err = reauthenticate(r, u, r.PostFormValue("password"), r.PostFormValue("code")); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			} else if p.Error == "" && r.PostFormValue("disable") != "" {
+				if err = disableTOTP(u.Username); err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				p.Enabled, p.Message = false, "Two-factor authentication is off."
+			} else if p.Error == "" {
+				if p.BackupCodes, err = newBackupCodes(u.Username); err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				p.Message = "Your old backup codes no longer work."
+			}
+		}
 
-func find(id string) (ClassifyBookResponse, error) {
-	var c ClassifyBookResponse
-	body, err := classifyAPI("http://classify.oclc.org/classify2/Classify?summary=true&owi=" + url.QueryEscape(id))
+		if !p.Enabled {
+			//  the secret is only stored on the user once a code proves the app has it
+			if p.Secret = getStringFromSession(r, "TOTPPending"); p.Secret == "" {
+				p.Secret = newTOTPSecret()
+				session.Set("TOTPPending", p.Secret)
+			}
+		}
+		template, err := ace.Load("templates/twofactor", "", nil)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		p.CSRFToken = csrfToken(r)
+		w.Header().Set("Cache-Control", "no-store")
+		if err = template.Execute(w, p); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}).Methods("GET", "POST")
 
-	if err != nil {
-		return ClassifyBookResponse{}, err
-	}
+	//  QR code of the secret being enrolled
+	mux.HandleFunc("/settings/2fa/qr.png", func(w http.ResponseWriter, r *http.Request) {
+		secret := getStringFromSession(r, "TOTPPending")
+		if secret == "" {
+			http.Error(w, "Not enrolling", http.StatusNotFound)
+			return
+		}
+		png, err := totpQRCode(currentUser(r), secret)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(png)
+	}).Methods("GET")
 
-	err = xml.Unmarshal(body, &c)
-	return c, err
-}
+	//  personal API tokens
+	mux.HandleFunc("/settings/tokens", func(w http.ResponseWriter, r *http.Request) {
+		p := TokensPage{User: currentUser(r)}
+		if r.Method == "POST" {
+			name, scope := strings.TrimSpace(r.PostFormValue("name")), r.PostFormValue("scope")
+			if name == "" {
+				p.Error = "Please name the token after what will use it"
+			} else if scope != "read" && scope != "write" {
+				p.Error = "Unknown scope " + scope
+			} else {
+				var err error
+				if p.NewToken, err = createAPIToken(p.User, name, scope); err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+			}
+		}
+		template, err := ace.Load("templates/tokens", "", nil)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if p.Tokens, err = userAPITokens(p.User); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		p.CSRFToken = csrfToken(r)
+		w.Header().Set("Cache-Control", "no-store")
+		if err = template.Execute(w, p); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}).Methods("GET", "POST")
+
+	//  revoke an API token
+	mux.HandleFunc("/settings/tokens/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		found, err := revokeAPIToken(currentUser(r), gmux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			http.Error(w, "No such token", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}).Methods("DELETE")
+
+	//  devices page: the user's active sessions
+	mux.HandleFunc("/sessions", func(w http.ResponseWriter, r *http.Request) {
+		template, err := ace.Load("templates/sessions", "", nil)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		p := SessionsPage{User: currentUser(r), CSRFToken: csrfToken(r)}
+		if p.Sessions, err = userSessions(p.User, currentSessionID(r)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err = template.Execute(w, p); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}).Methods("GET")
+
+	//  log out every other device
+	mux.HandleFunc("/sessions", func(w http.ResponseWriter, r *http.Request) {
+		if err := invalidateUserSessions(currentUser(r), currentSessionID(r)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}).Methods("DELETE")
+
+	//  log out one device
+	mux.HandleFunc("/sessions/{handle}", func(w http.ResponseWriter, r *http.Request) {
+		found, err := deleteUserSession(currentUser(r), gmux.Vars(r)["handle"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			http.Error(w, "No such session", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}).Methods("DELETE")
+
+	//  admin view of the usernames and IPs kept from logging in
+	mux.HandleFunc("/admin/lockouts", func(w http.ResponseWriter, r *http.Request) {
+		p := LockoutsPage{User: currentUser(r), CSRFToken: csrfToken(r)}
+		if !isAdmin(p.User) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		template, err := ace.Load("templates/lockouts", "", nil)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if p.Blocked, err = blockedLogins(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err = template.Execute(w, p); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}).Methods("GET")
 
-func search(query string) ([]SearchResult, error) {
-	var c ClassifySearchResponse
-	body, err := classifyAPI("http://classify.oclc.org/classify2/Classify?summary=true&title=" + url.QueryEscape(query))
+	//  unlock a username or IP
+	mux.HandleFunc("/admin/lockouts/{key}", func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(currentUser(r)) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if err := clearLoginFailures(gmux.Vars(r)["key"]); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}).Methods("DELETE")
 
+	//  OpenAPI document of the book routes, which requests to them are checked against
+	spec, err := openAPIDocument(mux)
 	if err != nil {
-		return []SearchResult{}, err
+		log.Fatal(err)
 	}
+	mux.HandleFunc("/api/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, spec.Document)
+	}).Methods("GET")
+	mux.Use(spec.validateRequest)
 
-	err = xml.Unmarshal(body, &c)
-	return c.Results, err
-}
-
-func classifyAPI(url string) ([]byte, error) {
-	var resp *http.Response
-	var err error
+	n := negroni.Classic()
+	keyPairs, err := sessionKeyPairs()
+	if err != nil {
+		log.Fatal(err)
+	}
+	sessionStore = NewDBStore(keyPairs...)
+	initVerification(keyPairs)
+	go purgeExpiredSessions(time.Hour)
+	go purgeLoginThrottle(time.Hour)
+	go purgeExpiredPasswordResets(time.Hour)
+	n.Use(sessions.Sessions(sessionName, sessionStore))
+	n.Use(negroni.HandlerFunc(verifyCSRF))
+	n.Use(negroni.HandlerFunc(verifyDatabase))
+	n.Use(negroni.HandlerFunc(verifyUser))
+	n.UseHandler(mux)
 
-	if resp, err = http.Get(url); err != nil {
-		return []byte{}, err
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
 	}
-	defer resp.Body.Close()
+	n.Run(":" + port)
 
-	return ioutil.ReadAll(resp.Body)
 }
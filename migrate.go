@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// migration is one numbered schema change, read from migrations/<dialect>/NNNN_name.{up,down}.sql
+type migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Requires string //the optional database feature named by a "-- requires: " line in up.sql, if any
+}
+
+// migrationRecord is a row of schema_migrations
+type migrationRecord struct {
+	Version   int64  `db:"version"`
+	Name      string `db:"name"`
+	AppliedAt int64  `db:"applied_at"` //unix seconds
+}
+
+const migrationsDir = "migrations"
+
+// arbitrary key shared by every dyno taking the Postgres advisory lock
+const migrationLockKey = 4242
+
+var migrationFile = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+var migrationRequires = regexp.MustCompile(`(?m)^-- requires: (\w+)\s*$`)
+
+// databaseFeatures are the optional features migrations can require. A migration requiring
+// one this build lacks stays pending, and is applied once a build with it starts.
+var databaseFeatures = map[string]bool{
+	"fts5": sqliteFTS5,
+}
+
+// available reports whether this build can apply m
+func (m migration) available() bool {
+	return m.Requires == "" || databaseFeatures[m.Requires]
+}
+
+func migrationDialect() string {
+	if isPostgres() {
+		return "postgres"
+	}
+	return "sqlite"
+}
+
+// loadMigrations reads the migrations for the current dialect, ordered by version
+func loadMigrations() ([]migration, error) {
+	dir := filepath.Join(migrationsDir, migrationDialect())
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*migration{}
+	for _, f := range files {
+		m := migrationFile.FindStringSubmatch(f.Name())
+		if m == nil {
+			continue
+		}
+		version, _ := strconv.Atoi(m[1])
+		body, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		} else if mig.Name != m[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, mig.Name, m[2])
+		}
+		if m[3] == "up" {
+			mig.Up = string(body)
+			if req := migrationRequires.FindStringSubmatch(mig.Up); req != nil {
+				mig.Requires = req[1]
+			}
+		} else {
+			mig.Down = string(body)
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up.sql", mig.Version, mig.Name)
+		}
+		migrations = append(migrations, *mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+func appliedMigrations() (map[int]migrationRecord, error) {
+	if _, err := dbmap.Exec("create table if not exists schema_migrations (" +
+		"version integer not null primary key, name varchar(255) not null, applied_at bigint not null)"); err != nil {
+		return nil, err
+	}
+
+	var records []migrationRecord
+	if _, err := dbmap.Select(&records, "select * from schema_migrations"); err != nil {
+		return nil, err
+	}
+	applied := map[int]migrationRecord{}
+	for _, r := range records {
+		applied[int(r.Version)] = r
+	}
+	return applied, nil
+}
+
+// withMigrationLock keeps two processes from migrating the same database at once.
+// Postgres uses a session advisory lock; SQLite serializes the migration transactions itself.
+func withMigrationLock(fn func() error) error {
+	if !isPostgres() {
+		return fn()
+	}
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err = conn.ExecContext(ctx, "select pg_advisory_lock($1)", migrationLockKey); err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, "select pg_advisory_unlock($1)", migrationLockKey)
+	return fn()
+}
+
+// migrateUp applies every migration that has not been applied yet
+func migrateUp() error {
+	return withMigrationLock(func() error {
+		migrations, err := loadMigrations()
+		if err != nil {
+			return err
+		}
+		applied, err := appliedMigrations()
+		if err != nil {
+			return err
+		}
+
+		for _, m := range migrations {
+			if _, ok := applied[m.Version]; ok || !m.available() {
+				continue
+			}
+			if err := applyMigration(m, m.Up, true); err != nil {
+				return fmt.Errorf("migration %d_%s: %s", m.Version, m.Name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// migrateDown rolls back the most recent steps applied migrations
+func migrateDown(steps int) error {
+	return withMigrationLock(func() error {
+		migrations, err := loadMigrations()
+		if err != nil {
+			return err
+		}
+		applied, err := appliedMigrations()
+		if err != nil {
+			return err
+		}
+
+		for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+			m := migrations[i]
+			if _, ok := applied[m.Version]; !ok {
+				continue
+			}
+			if m.Down == "" {
+				return fmt.Errorf("migration %d_%s cannot be rolled back: no down.sql", m.Version, m.Name)
+			}
+			if err := applyMigration(m, m.Down, false); err != nil {
+				return fmt.Errorf("rollback %d_%s: %s", m.Version, m.Name, err)
+			}
+			steps--
+		}
+		return nil
+	})
+}
+
+// applyMigration runs one migration script and records it in a single transaction
+func applyMigration(m migration, script string, up bool) error {
+	tx, err := dbmap.Begin()
+	if err != nil {
+		return err
+	}
+
+	if up {
+		_, err = tx.Exec("insert into schema_migrations (version, name, applied_at) values ("+
+			dbmap.Dialect.BindVar(0)+", "+dbmap.Dialect.BindVar(1)+", "+dbmap.Dialect.BindVar(2)+")",
+			m.Version, m.Name, time.Now().Unix())
+	} else {
+		_, err = tx.Exec("delete from schema_migrations where version="+dbmap.Dialect.BindVar(0), m.Version)
+	}
+	if err == nil {
+		_, err = tx.Exec(script)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// migrationStatus writes one line per known migration to w
+func migrationStatus(w io.Writer) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	applied, err := appliedMigrations()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		state := "pending"
+		if r, ok := applied[m.Version]; ok {
+			state = "applied " + time.Unix(r.AppliedAt, 0).Format(time.RFC3339)
+		} else if !m.available() {
+			state = "pending, needs a build with " + m.Requires
+		}
+		fmt.Fprintf(w, "%04d_%s\t%s\n", m.Version, m.Name, state)
+	}
+	return nil
+}
+
+// runMigrateCommand implements `go-for-web-dev migrate up|down [steps]|status`
+func runMigrateCommand(args []string, w io.Writer) error {
+	if len(args) == 0 {
+		return errors.New("usage: migrate up|down [steps]|status")
+	}
+	switch args[0] {
+	case "up":
+		return migrateUp()
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			var err error
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return errors.New("migrate down: steps must be a positive number")
+			}
+		}
+		return migrateDown(steps)
+	case "status":
+		return migrationStatus(w)
+	}
+	return errors.New("unknown migrate command: " + args[0])
+}
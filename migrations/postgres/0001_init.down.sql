@@ -0,0 +1,2 @@
+drop table if exists users;
+drop table if exists books;
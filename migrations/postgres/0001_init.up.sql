@@ -0,0 +1,12 @@
+create table if not exists books (
+	pk bigserial not null primary key,
+	title varchar(255),
+	author varchar(255),
+	classification varchar(255),
+	id varchar(255),
+	"user" varchar(255)
+);
+create table if not exists users (
+	username varchar(255) not null primary key,
+	secret bytea
+);
@@ -0,0 +1 @@
+alter table books add column if not exists notes text not null default '';
@@ -0,0 +1,8 @@
+alter table books
+	drop column if exists isbn10,
+	drop column if exists isbn13,
+	drop column if exists year,
+	drop column if exists publisher,
+	drop column if exists edition,
+	drop column if exists page_count,
+	drop column if exists language;
@@ -0,0 +1,8 @@
+alter table books
+	add column if not exists isbn10 varchar(10) not null default '',
+	add column if not exists isbn13 varchar(13) not null default '',
+	add column if not exists year integer not null default 0,
+	add column if not exists publisher varchar(255) not null default '',
+	add column if not exists edition varchar(255) not null default '',
+	add column if not exists page_count integer not null default 0,
+	add column if not exists language varchar(16) not null default '';
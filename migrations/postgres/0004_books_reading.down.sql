@@ -0,0 +1,4 @@
+alter table books
+	drop column if exists shelves,
+	drop column if exists rating,
+	drop column if exists date_read;
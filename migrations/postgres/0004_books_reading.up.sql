@@ -0,0 +1,4 @@
+alter table books
+	add column if not exists shelves text not null default '',
+	add column if not exists rating real not null default 0,
+	add column if not exists date_read varchar(10) not null default '';
@@ -0,0 +1,2 @@
+drop table if exists jobs;
+alter table books drop column if exists status;
@@ -0,0 +1,13 @@
+alter table books add column if not exists status varchar(16) not null default '';
+create table if not exists jobs (
+	id bigserial not null primary key,
+	kind varchar(64) not null,
+	payload text not null,
+	status varchar(16) not null default 'pending',
+	attempts integer not null default 0,
+	run_at bigint not null,
+	last_error text not null default '',
+	created_at bigint not null,
+	updated_at bigint not null
+);
+create index if not exists jobs_status_run_at on jobs (status, run_at);
@@ -0,0 +1,7 @@
+create table if not exists api_cache (
+	cache_key varchar(512) not null primary key,
+	body bytea not null,
+	not_found integer not null default 0,
+	expires_at bigint not null
+);
+create index if not exists api_cache_expires_at on api_cache (expires_at);
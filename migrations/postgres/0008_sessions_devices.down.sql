@@ -0,0 +1,4 @@
+alter table sessions
+	drop column if exists ip,
+	drop column if exists user_agent,
+	drop column if exists last_seen_at;
@@ -0,0 +1,5 @@
+alter table sessions
+	add column if not exists ip varchar(64) not null default '',
+	add column if not exists user_agent text not null default '',
+	add column if not exists last_seen_at bigint not null default 0;
+update sessions set last_seen_at = updated_at;
@@ -0,0 +1,7 @@
+create table if not exists password_resets (
+	token_hash varchar(64) not null primary key,
+	username varchar(255) not null,
+	created_at bigint not null,
+	expires_at bigint not null
+);
+create index if not exists password_resets_username on password_resets (username);
@@ -0,0 +1,3 @@
+alter table users
+	drop column if exists verified,
+	drop column if exists verification_sent_at;
@@ -0,0 +1,5 @@
+alter table users
+	add column if not exists verified integer not null default 0,
+	add column if not exists verification_sent_at bigint not null default 0;
+-- accounts made before verification existed keep working
+update users set verified = 1;
@@ -0,0 +1,5 @@
+drop table if exists backup_codes;
+alter table users
+	drop column if exists totp_secret,
+	drop column if exists totp_enabled,
+	drop column if exists totp_last_step;
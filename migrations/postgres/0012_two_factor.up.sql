@@ -0,0 +1,9 @@
+alter table users
+	add column if not exists totp_secret varchar(64) not null default '',
+	add column if not exists totp_enabled integer not null default 0,
+	add column if not exists totp_last_step bigint not null default 0;
+create table if not exists backup_codes (
+	username varchar(255) not null,
+	code_hash varchar(64) not null,
+	primary key (username, code_hash)
+);
@@ -0,0 +1,10 @@
+create table if not exists api_tokens (
+	id bigserial not null primary key,
+	username varchar(255) not null,
+	name varchar(255) not null default '',
+	token_hash varchar(64) not null unique,
+	scope varchar(16) not null default 'read',
+	created_at bigint not null,
+	last_used_at bigint not null default 0
+);
+create index if not exists api_tokens_username on api_tokens (username);
@@ -0,0 +1 @@
+drop index if exists books_search_idx;
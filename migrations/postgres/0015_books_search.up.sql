@@ -0,0 +1,3 @@
+-- the expression must stay the same as postgresSearchDocument for searches to use the index
+create index if not exists books_search_idx on books using gin (to_tsvector('english',
+	coalesce(title, '') || ' ' || coalesce(author, '') || ' ' || coalesce(classification, '') || ' ' || coalesce(notes, '')));
@@ -0,0 +1,12 @@
+create table if not exists books (
+	pk integer primary key autoincrement,
+	title text,
+	author text,
+	id text,
+	classification text,
+	"user" varchar(255)
+);
+create table if not exists users (
+	username varchar(255) not null primary key,
+	secret blob
+);
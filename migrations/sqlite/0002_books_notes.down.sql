@@ -0,0 +1,12 @@
+create table books_without_notes (
+	pk integer primary key autoincrement,
+	title text,
+	author text,
+	id text,
+	classification text,
+	"user" varchar(255)
+);
+insert into books_without_notes (pk, title, author, id, classification, "user")
+	select pk, title, author, id, classification, "user" from books;
+drop table books;
+alter table books_without_notes rename to books;
@@ -0,0 +1 @@
+alter table books add column notes text not null default '';
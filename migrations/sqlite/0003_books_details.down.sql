@@ -0,0 +1,13 @@
+create table books_without_details (
+	pk integer primary key autoincrement,
+	title text,
+	author text,
+	id text,
+	classification text,
+	"user" varchar(255),
+	notes text not null default ''
+);
+insert into books_without_details (pk, title, author, id, classification, "user", notes)
+	select pk, title, author, id, classification, "user", notes from books;
+drop table books;
+alter table books_without_details rename to books;
@@ -0,0 +1,7 @@
+alter table books add column isbn10 varchar(10) not null default '';
+alter table books add column isbn13 varchar(13) not null default '';
+alter table books add column year integer not null default 0;
+alter table books add column publisher text not null default '';
+alter table books add column edition text not null default '';
+alter table books add column page_count integer not null default 0;
+alter table books add column language varchar(16) not null default '';
@@ -0,0 +1,22 @@
+create table books_without_reading (
+	pk integer primary key autoincrement,
+	title text,
+	author text,
+	id text,
+	classification text,
+	"user" varchar(255),
+	notes text not null default '',
+	isbn10 varchar(10) not null default '',
+	isbn13 varchar(13) not null default '',
+	year integer not null default 0,
+	publisher text not null default '',
+	edition text not null default '',
+	page_count integer not null default 0,
+	language varchar(16) not null default ''
+);
+insert into books_without_reading (pk, title, author, id, classification, "user", notes,
+		isbn10, isbn13, year, publisher, edition, page_count, language)
+	select pk, title, author, id, classification, "user", notes,
+		isbn10, isbn13, year, publisher, edition, page_count, language from books;
+drop table books;
+alter table books_without_reading rename to books;
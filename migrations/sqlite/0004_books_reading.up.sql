@@ -0,0 +1,3 @@
+alter table books add column shelves text not null default '';
+alter table books add column rating real not null default 0;
+alter table books add column date_read varchar(10) not null default '';
@@ -0,0 +1,26 @@
+drop table if exists jobs;
+create table books_without_status (
+	pk integer primary key autoincrement,
+	title text,
+	author text,
+	id text,
+	classification text,
+	"user" varchar(255),
+	notes text not null default '',
+	isbn10 varchar(10) not null default '',
+	isbn13 varchar(13) not null default '',
+	year integer not null default 0,
+	publisher text not null default '',
+	edition text not null default '',
+	page_count integer not null default 0,
+	language varchar(16) not null default '',
+	shelves text not null default '',
+	rating real not null default 0,
+	date_read varchar(10) not null default ''
+);
+insert into books_without_status (pk, title, author, id, classification, "user", notes,
+		isbn10, isbn13, year, publisher, edition, page_count, language, shelves, rating, date_read)
+	select pk, title, author, id, classification, "user", notes,
+		isbn10, isbn13, year, publisher, edition, page_count, language, shelves, rating, date_read from books;
+drop table books;
+alter table books_without_status rename to books;
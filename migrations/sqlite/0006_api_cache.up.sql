@@ -0,0 +1,7 @@
+create table api_cache (
+	cache_key varchar(512) not null primary key,
+	body blob not null,
+	not_found integer not null default 0,
+	expires_at bigint not null
+);
+create index api_cache_expires_at on api_cache (expires_at);
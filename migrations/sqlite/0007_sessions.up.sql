@@ -0,0 +1,10 @@
+create table sessions (
+	id varchar(64) not null primary key,
+	username varchar(255) not null default '',
+	data text not null,
+	created_at bigint not null,
+	updated_at bigint not null,
+	expires_at bigint not null
+);
+create index sessions_username on sessions (username);
+create index sessions_expires_at on sessions (expires_at);
@@ -0,0 +1,14 @@
+create table sessions_without_devices (
+	id varchar(64) not null primary key,
+	username varchar(255) not null default '',
+	data text not null,
+	created_at bigint not null,
+	updated_at bigint not null,
+	expires_at bigint not null
+);
+insert into sessions_without_devices (id, username, data, created_at, updated_at, expires_at)
+	select id, username, data, created_at, updated_at, expires_at from sessions;
+drop table sessions;
+alter table sessions_without_devices rename to sessions;
+create index sessions_username on sessions (username);
+create index sessions_expires_at on sessions (expires_at);
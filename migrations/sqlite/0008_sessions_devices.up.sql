@@ -0,0 +1,4 @@
+alter table sessions add column ip varchar(64) not null default '';
+alter table sessions add column user_agent text not null default '';
+alter table sessions add column last_seen_at bigint not null default 0;
+update sessions set last_seen_at = updated_at;
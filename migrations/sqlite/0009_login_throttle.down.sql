@@ -0,0 +1 @@
+drop table if exists login_throttle;
@@ -0,0 +1,7 @@
+create table login_throttle (
+	throttle_key varchar(320) not null primary key,
+	failures integer not null default 0,
+	last_failure_at bigint not null,
+	blocked_until bigint not null
+);
+create index login_throttle_blocked_until on login_throttle (blocked_until);
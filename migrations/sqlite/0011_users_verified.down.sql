@@ -0,0 +1,7 @@
+create table users_without_verified (
+	username varchar(255) not null primary key,
+	secret blob
+);
+insert into users_without_verified (username, secret) select username, secret from users;
+drop table users;
+alter table users_without_verified rename to users;
@@ -0,0 +1,4 @@
+alter table users add column verified integer not null default 0;
+alter table users add column verification_sent_at bigint not null default 0;
+-- accounts made before verification existed keep working
+update users set verified = 1;
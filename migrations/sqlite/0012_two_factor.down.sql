@@ -0,0 +1,11 @@
+drop table if exists backup_codes;
+create table users_without_totp (
+	username varchar(255) not null primary key,
+	secret blob,
+	verified integer not null default 0,
+	verification_sent_at bigint not null default 0
+);
+insert into users_without_totp (username, secret, verified, verification_sent_at)
+	select username, secret, verified, verification_sent_at from users;
+drop table users;
+alter table users_without_totp rename to users;
@@ -0,0 +1,8 @@
+alter table users add column totp_secret varchar(64) not null default '';
+alter table users add column totp_enabled integer not null default 0;
+alter table users add column totp_last_step bigint not null default 0;
+create table backup_codes (
+	username varchar(255) not null,
+	code_hash varchar(64) not null,
+	primary key (username, code_hash)
+);
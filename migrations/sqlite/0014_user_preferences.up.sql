@@ -0,0 +1,6 @@
+create table user_preferences (
+	username varchar(255) not null primary key,
+	filter varchar(16) not null default '',
+	sort varchar(255) not null default '',
+	updated_at bigint not null default 0
+);
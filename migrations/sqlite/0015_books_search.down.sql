@@ -0,0 +1,4 @@
+drop trigger if exists books_fts_ai;
+drop trigger if exists books_fts_ad;
+drop trigger if exists books_fts_au;
+drop table if exists books_fts;
@@ -0,0 +1,22 @@
+-- requires: fts5
+-- databases searched before this migration already have the index, without a version
+drop trigger if exists books_fts_ai;
+drop trigger if exists books_fts_ad;
+drop trigger if exists books_fts_au;
+drop table if exists books_fts;
+create virtual table books_fts using fts5(title, author, classification, notes, content='books', content_rowid='pk');
+create trigger books_fts_ai after insert on books begin
+	insert into books_fts(rowid, title, author, classification, notes)
+		values (new.pk, new.title, new.author, new.classification, new.notes);
+end;
+create trigger books_fts_ad after delete on books begin
+	insert into books_fts(books_fts, rowid, title, author, classification, notes)
+		values ('delete', old.pk, old.title, old.author, old.classification, old.notes);
+end;
+create trigger books_fts_au after update on books begin
+	insert into books_fts(books_fts, rowid, title, author, classification, notes)
+		values ('delete', old.pk, old.title, old.author, old.classification, old.notes);
+	insert into books_fts(rowid, title, author, classification, notes)
+		values (new.pk, new.title, new.author, new.classification, new.notes);
+end;
+insert into books_fts(books_fts) values ('rebuild');
@@ -0,0 +1,691 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	gmux "github.com/gorilla/mux"
+)
+
+// apiParam is a parameter of a documented route. Path parameters and the query parameters
+// a route matches on are read from the route itself; the others are listed in apiOperations.
+type apiParam struct {
+	Name        string
+	In          string //"path", "query" or "form", a field of a form encoded or multipart body
+	Description string
+	Type        string //"string" when empty, "integer", or "file", an upload of a multipart body
+	Enum        []string
+	Pattern     string
+	Min, Max    *int
+	Required    bool
+
+	pattern *regexp.Regexp //Pattern, compiled once by routeOperation
+}
+
+// apiOperation documents what one route takes and returns
+type apiOperation struct {
+	Summary  string
+	Params   []apiParam
+	Body     interface{} //a value of the JSON request body's type, nil for none
+	Status   int         //200 when zero
+	Response interface{} //a value of the JSON response's type, nil for none
+	Produces string      //content type of a response that is not JSON
+}
+
+// apiOperations documents the book routes, keyed by method and path template, followed by
+// the names of the query parameters the route matches on. openAPIDocument refuses a router
+// whose book routes and these disagree, so the document cannot drift from the code.
+var apiOperations = map[string]apiOperation{
+	"GET /api/v1/books": {
+		Summary: "List the user's books",
+		Params: append([]apiParam{
+			{Name: "filter", In: "query", Enum: []string{"all", "fiction", "nonfiction"}},
+			bookSortParam,
+		}, bookPageParams...),
+		Response: APIBookList{},
+	},
+	"POST /api/v1/books": {
+		Summary:  "Add a book, filled in by the metadata provider in the background when only source and id are given",
+		Body:     APIBookInput{},
+		Status:   http.StatusCreated,
+		Response: APIBook{},
+	},
+	"GET /api/v1/books/{pk:[0-9]+}":    {Summary: "Get a book", Response: APIBook{}},
+	"PATCH /api/v1/books/{pk:[0-9]+}":  {Summary: "Change the fields given of a book", Body: APIBookInput{}, Response: APIBook{}},
+	"DELETE /api/v1/books/{pk:[0-9]+}": {Summary: "Delete a book", Status: http.StatusNoContent},
+	"GET /api/v1/users/{username}":     {Summary: "Get the authenticated user, also known as me", Response: APIUser{}},
+
+	"GET /books": {
+		Summary: "List the library, filtered and sorted as given, or else as last time, and remember the choice",
+		Params: append([]apiParam{
+			{Name: "filter", In: "query", Enum: []string{"all", "fiction", "nonfiction"}},
+			bookSortParam,
+		}, bookPageParams...),
+		Response: []Book{},
+	},
+	"GET /books?q": {Summary: "Search the library", Response: []BookHit{}},
+	"POST /search": {
+		Summary: "Search the metadata providers",
+		Params: []apiParam{
+			{Name: "search", In: "form", Description: "title"},
+			{Name: "author", In: "form"},
+			{Name: "isbn", In: "form"},
+		},
+		Response: []SearchResult{},
+	},
+	"PUT /books?isbn": {Summary: "Add the book with an ISBN", Response: Book{}},
+	"PUT /books": {
+		Summary: "Add a search result, filled in by the metadata provider in the background",
+		Params: []apiParam{
+			{Name: "id", In: "form", Required: true},
+			{Name: "source", In: "form", Description: "metadata provider of id, classify when empty"},
+			{Name: "title", In: "form"},
+			{Name: "author", In: "form"},
+		},
+		Response: Book{},
+	},
+	"GET /books/export.csv": {Summary: "Export the library", Produces: "text/csv"},
+	"POST /books/import": {
+		Summary: "Import books from a multipart file upload or the request body",
+		Params: []apiParam{
+			{Name: "file", In: "form", Type: "file"},
+			{Name: "format", In: "form", Enum: []string{"csv", "goodreads", "librarything"},
+				Description: "in the query string when the file is the request body"},
+		},
+		Response: ImportResult{},
+	},
+	"GET /books/{pk:[0-9]+}": {Summary: "Get a book", Response: Book{}},
+	"PATCH /books/{pk:[0-9]+}": {
+		Summary: "Change the fields given of a book",
+		Params: []apiParam{
+			{Name: "title", In: "form"},
+			{Name: "author", In: "form"},
+			{Name: "classification", In: "form"},
+			{Name: "notes", In: "form"},
+		},
+		Response: Book{},
+	},
+	"DELETE /books/{pk:[0-9]+}": {Summary: "Delete a book"},
+}
+
+var sortColumnPattern = "-?(" + strings.Join(sortedKeys(bookSortColumns), "|") + ")"
+
+var bookSortParam = apiParam{Name: "sort", In: "query", Pattern: "^(" + sortColumnPattern + "(," + sortColumnPattern + ")*)?$",
+	Description: "columns to sort by, separated by commas, each descending with a leading -"}
+
+// the parameters of paged listings; the Link header of a page has the URL of the next one
+var bookPageParams = []apiParam{
+	{Name: "limit", In: "query", Type: "integer", Min: intp(1), Max: intp(maxBookPageSize)},
+	{Name: "offset", In: "query", Type: "integer", Min: intp(0)},
+	{Name: "cursor", In: "query", Description: "next_cursor of the previous page, or the cursor in its Link header"},
+}
+
+// the routes that must be in apiOperations
+func documentedPath(template string) bool {
+	return template == "/search" || template == "/books" || strings.HasPrefix(template, "/books/") ||
+		strings.HasPrefix(template, "/api/")
+}
+
+// maxAPIBody limits the JSON bodies validateRequest reads
+const maxAPIBody = 1 << 20
+
+func intp(n int) *int {
+	return &n
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type jsonObject map[string]interface{}
+
+// routeOperation is an apiOperation together with what its route adds to it
+type routeOperation struct {
+	apiOperation
+	Method     string
+	Path       string //OpenAPI path, without the patterns
+	AllParams  []apiParam
+	BodySchema jsonObject
+}
+
+// OpenAPI is the document served at /api/openapi.json, and what validateRequest checks requests against
+type OpenAPI struct {
+	Document   jsonObject
+	schemas    jsonObject
+	operations map[*gmux.Route]map[string]*routeOperation
+}
+
+var routeVariable = regexp.MustCompile(`\{([^{}:]+)(?::([^{}]+))?\}`)
+
+// openAPIDocument documents the routes of mux that are in apiOperations, with the schemas of
+// the Go types they send and receive
+func openAPIDocument(mux *gmux.Router) (*OpenAPI, error) {
+	d := &OpenAPI{schemas: jsonObject{}, operations: map[*gmux.Route]map[string]*routeOperation{}}
+	var ops []*routeOperation
+	found := map[string]bool{}
+	err := mux.Walk(func(route *gmux.Route, router *gmux.Router, ancestors []*gmux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil || !documentedPath(template) {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			//  a subrouter's prefix, its routes are visited next
+			return nil
+		}
+		queries, _ := route.GetQueriesTemplates()
+		for _, method := range methods {
+			key := method + " " + template
+			var names []string
+			for _, q := range queries {
+				names = append(names, strings.SplitN(q, "=", 2)[0])
+			}
+			if len(names) > 0 {
+				key += "?" + strings.Join(names, "&")
+			}
+			op, ok := apiOperations[key]
+			if !ok {
+				return errors.New("openapi: route " + key + " is not in apiOperations")
+			}
+			found[key] = true
+
+			rop := d.routeOperation(method, template, queries, op)
+			if d.operations[route] == nil {
+				d.operations[route] = map[string]*routeOperation{}
+			}
+			d.operations[route][method] = rop
+			ops = append(ops, rop)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for key := range apiOperations {
+		if !found[key] {
+			return nil, errors.New("openapi: " + key + " in apiOperations is not a route")
+		}
+	}
+
+	paths := jsonObject{}
+	for _, group := range groupOperations(ops) {
+		item, ok := paths[group[0].Path].(jsonObject)
+		if !ok {
+			item = jsonObject{}
+			paths[group[0].Path] = item
+		}
+		item[strings.ToLower(group[0].Method)] = d.operation(group)
+	}
+	d.Document = jsonObject{
+		"openapi": "3.0.3",
+		"info": jsonObject{
+			"title":   "go-for-web-dev",
+			"version": "1",
+		},
+		"paths": paths,
+		"components": jsonObject{
+			"schemas": d.schemas,
+			"securitySchemes": jsonObject{
+				"token":   jsonObject{"type": "http", "scheme": "bearer", "description": "personal API token from /settings/tokens"},
+				"session": jsonObject{"type": "apiKey", "in": "cookie", "name": sessionName},
+			},
+		},
+		"security": []jsonObject{{"token": []string{}}, {"session": []string{}}},
+	}
+	return d, nil
+}
+
+// routeOperation adds the variables in the route's path and queries to op's parameters
+func (d *OpenAPI) routeOperation(method string, template string, queries []string, op apiOperation) *routeOperation {
+	rop := &routeOperation{apiOperation: op, Method: method}
+	rop.Path = routeVariable.ReplaceAllStringFunc(template, func(v string) string {
+		m := routeVariable.FindStringSubmatch(v)
+		rop.AllParams = append(rop.AllParams, routeParam(m[1], "path", m[2]))
+		return "{" + m[1] + "}"
+	})
+	for _, q := range queries {
+		kv := strings.SplitN(q, "=", 2)
+		if m := routeVariable.FindStringSubmatch(kv[1]); m != nil {
+			rop.AllParams = append(rop.AllParams, routeParam(kv[0], "query", m[2]))
+		} else {
+			rop.AllParams = append(rop.AllParams, apiParam{Name: kv[0], In: "query", Enum: []string{kv[1]}, Required: true})
+		}
+	}
+	rop.AllParams = append(rop.AllParams, op.Params...)
+	for i, p := range rop.AllParams {
+		if p.Pattern != "" {
+			rop.AllParams[i].pattern = regexp.MustCompile(p.Pattern)
+		}
+	}
+	if op.Body != nil {
+		rop.BodySchema = d.schema(reflect.TypeOf(op.Body))
+	}
+	return rop
+}
+
+var wordAlternatives = regexp.MustCompile(`^[A-Za-z0-9_.]+(\|[A-Za-z0-9_.]+)*$`)
+
+// routeParam is the required parameter a route variable matching pattern stands for
+func routeParam(name string, in string, pattern string) apiParam {
+	p := apiParam{Name: name, In: in, Required: true}
+	if wordAlternatives.MatchString(pattern) {
+		p.Enum = strings.Split(pattern, "|")
+	} else if pattern != "" {
+		p.Pattern = "^(" + pattern + ")$"
+	}
+	return p
+}
+
+// groupOperations puts together the routes OpenAPI sees as one operation, those that only
+// differ in the query parameters they match on, keeping the order of the router
+func groupOperations(ops []*routeOperation) [][]*routeOperation {
+	var groups [][]*routeOperation
+	index := map[string]int{}
+	for _, op := range ops {
+		key := op.Method + " " + op.Path
+		if i, ok := index[key]; ok {
+			groups[i] = append(groups[i], op)
+			continue
+		}
+		index[key] = len(groups)
+		groups = append(groups, []*routeOperation{op})
+	}
+	return groups
+}
+
+// operation is the OpenAPI operation object of a group of routes. A parameter is only
+// required if every route of the group requires it.
+func (d *OpenAPI) operation(group []*routeOperation) jsonObject {
+	var summaries []string
+	var params []apiParam
+	count := map[string]int{}
+	for _, op := range group {
+		summaries = append(summaries, op.Summary)
+		for _, p := range op.AllParams {
+			if count[p.In+" "+p.Name] == 0 {
+				params = append(params, p)
+			}
+			count[p.In+" "+p.Name]++
+		}
+	}
+
+	o := jsonObject{"summary": strings.Join(summaries, "; ")}
+	var parameters []jsonObject
+	form := jsonObject{"type": "object", "properties": jsonObject{}}
+	var formRequired []string
+	formType := "application/x-www-form-urlencoded"
+	for _, p := range params {
+		required := p.Required && count[p.In+" "+p.Name] == len(group)
+		if p.In == "form" {
+			schema := p.schema()
+			if p.Description != "" {
+				schema["description"] = p.Description
+			}
+			form["properties"].(jsonObject)[p.Name] = schema
+			if p.Type == "file" {
+				formType = "multipart/form-data"
+			}
+			if required {
+				formRequired = append(formRequired, p.Name)
+			}
+			continue
+		}
+		param := jsonObject{"name": p.Name, "in": p.In, "required": required, "schema": p.schema()}
+		if p.Description != "" {
+			param["description"] = p.Description
+		}
+		parameters = append(parameters, param)
+	}
+	if len(parameters) > 0 {
+		o["parameters"] = parameters
+	}
+	if len(formRequired) > 0 {
+		form["required"] = formRequired
+	}
+	if len(form["properties"].(jsonObject)) > 0 {
+		o["requestBody"] = jsonObject{"content": jsonObject{formType: jsonObject{"schema": form}}}
+	}
+
+	responses := jsonObject{}
+	bodies := map[string][]interface{}{}
+	for _, op := range group {
+		if op.BodySchema != nil {
+			o["requestBody"] = jsonObject{
+				"required": true,
+				"content":  jsonObject{"application/json": jsonObject{"schema": op.BodySchema}},
+			}
+		}
+		status := op.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		code := strconv.Itoa(status)
+		response, ok := responses[code].(jsonObject)
+		if !ok {
+			response = jsonObject{"description": http.StatusText(status)}
+			responses[code] = response
+		}
+		if op.Response != nil {
+			schema := d.schema(reflect.TypeOf(op.Response))
+			if !containsSchema(bodies[code], schema) {
+				bodies[code] = append(bodies[code], schema)
+			}
+		} else if op.Produces != "" {
+			response["content"] = jsonObject{op.Produces: jsonObject{"schema": jsonObject{"type": "string"}}}
+		}
+	}
+	for code, schemas := range bodies {
+		var schema interface{} = schemas[0]
+		if len(schemas) > 1 {
+			//  the routes of the group answer with different types
+			schema = jsonObject{"oneOf": schemas}
+		}
+		responses[code].(jsonObject)["content"] = jsonObject{"application/json": jsonObject{"schema": schema}}
+	}
+	if strings.HasPrefix(group[0].Path, "/api/") {
+		responses["default"] = jsonObject{
+			"description": "Error",
+			"content":     jsonObject{"application/json": jsonObject{"schema": d.schema(reflect.TypeOf(APIError{}))}},
+		}
+	} else {
+		responses["default"] = jsonObject{
+			"description": "Error",
+			"content":     jsonObject{"text/plain": jsonObject{"schema": jsonObject{"type": "string"}}},
+		}
+	}
+	o["responses"] = responses
+	return o
+}
+
+func containsSchema(schemas []interface{}, schema jsonObject) bool {
+	for _, s := range schemas {
+		if reflect.DeepEqual(s, schema) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p apiParam) schema() jsonObject {
+	s := jsonObject{"type": "string"}
+	if p.Type == "file" {
+		s["format"] = "binary"
+	} else if p.Type != "" {
+		s["type"] = p.Type
+	}
+	if len(p.Enum) > 0 {
+		s["enum"] = p.Enum
+	}
+	if p.Pattern != "" {
+		s["pattern"] = p.Pattern
+	}
+	if p.Min != nil {
+		s["minimum"] = *p.Min
+	}
+	if p.Max != nil {
+		s["maximum"] = *p.Max
+	}
+	return s
+}
+
+// check returns why value is not valid for p, "" if it is. present is whether the
+// parameter was sent at all.
+func (p apiParam) check(value string, present bool) string {
+	if !present {
+		if p.Required {
+			return p.Name + " is required"
+		}
+		return ""
+	}
+	if p.Type == "integer" {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return p.Name + " must be a whole number"
+		}
+		if (p.Min != nil && n < *p.Min) || (p.Max != nil && n > *p.Max) {
+			return p.Name + " is out of range"
+		}
+	}
+	if len(p.Enum) > 0 && !stringIn(value, p.Enum) {
+		return p.Name + " must be one of " + strings.Join(p.Enum, ", ")
+	}
+	if p.pattern != nil && !p.pattern.MatchString(value) {
+		return p.Name + " must match " + p.Pattern
+	}
+	return ""
+}
+
+func stringIn(s string, list []string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// schema is the JSON schema of what encoding/json makes of t. Structs become components, and
+// their fields are required unless they are pointers or omitempty.
+func (d *OpenAPI) schema(t reflect.Type) jsonObject {
+	switch t.Kind() {
+	case reflect.Ptr:
+		s := d.schema(t.Elem())
+		if _, ref := s["$ref"]; !ref {
+			s["nullable"] = true
+		}
+		return s
+	case reflect.String:
+		return jsonObject{"type": "string"}
+	case reflect.Bool:
+		return jsonObject{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return jsonObject{"type": "integer", "format": "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return jsonObject{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return jsonObject{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return jsonObject{"type": "string", "format": "byte"}
+		}
+		return jsonObject{"type": "array", "items": d.schema(t.Elem())}
+	case reflect.Map:
+		return jsonObject{"type": "object", "additionalProperties": d.schema(t.Elem())}
+	case reflect.Struct:
+		if _, ok := d.schemas[t.Name()]; !ok {
+			d.schemas[t.Name()] = jsonObject{}
+			d.schemas[t.Name()] = d.object(t)
+		}
+		return jsonObject{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return jsonObject{}
+}
+
+func (d *OpenAPI) object(t reflect.Type) jsonObject {
+	properties := jsonObject{}
+	var required []string
+	var fields func(t reflect.Type)
+	fields = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := strings.Split(f.Tag.Get("json"), ",")
+			if f.Anonymous && tag[0] == "" && f.Type.Kind() == reflect.Struct {
+				fields(f.Type)
+				continue
+			}
+			if f.PkgPath != "" || tag[0] == "-" {
+				continue
+			}
+			name := f.Name
+			if tag[0] != "" {
+				name = tag[0]
+			}
+			properties[name] = d.schema(f.Type)
+			if f.Type.Kind() != reflect.Ptr && !stringIn("omitempty", tag[1:]) {
+				required = append(required, name)
+			}
+		}
+	}
+	fields(t)
+
+	s := jsonObject{"type": "object", "properties": properties, "additionalProperties": false}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+// validate returns why v, decoded with UseNumber, does not match schema s
+func (d *OpenAPI) validate(s jsonObject, v interface{}, at string) error {
+	if ref, ok := s["$ref"].(string); ok {
+		s = d.schemas[strings.TrimPrefix(ref, "#/components/schemas/")].(jsonObject)
+	}
+	if v == nil {
+		if s["nullable"] == true {
+			return nil
+		}
+		return fmt.Errorf("%s must not be null", at)
+	}
+
+	switch s["type"] {
+	case "object":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s must be an object", at)
+		}
+		properties, _ := s["properties"].(jsonObject)
+		required, _ := s["required"].([]string)
+		for _, name := range required {
+			if _, ok := obj[name]; !ok {
+				return fmt.Errorf("%s.%s is required", at, name)
+			}
+		}
+		for name, value := range obj {
+			property, ok := properties[name].(jsonObject)
+			if !ok {
+				return fmt.Errorf("%s has no field %s", at, name)
+			}
+			if err := d.validate(property, value, at+"."+name); err != nil {
+				return err
+			}
+		}
+	case "array":
+		list, ok := v.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s must be an array", at)
+		}
+		for i, item := range list {
+			if err := d.validate(s["items"].(jsonObject), item, at+"["+strconv.Itoa(i)+"]"); err != nil {
+				return err
+			}
+		}
+	case "string":
+		if _, ok := v.(string); !ok {
+			return fmt.Errorf("%s must be a string", at)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fmt.Errorf("%s must be true or false", at)
+		}
+	case "integer":
+		n, ok := v.(json.Number)
+		if !ok {
+			return fmt.Errorf("%s must be a whole number", at)
+		}
+		bits := 32
+		if s["format"] == "int64" {
+			bits = 64
+		}
+		if _, err := strconv.ParseInt(n.String(), 10, bits); err != nil {
+			return fmt.Errorf("%s must be a whole number", at)
+		}
+	case "number":
+		if _, ok := v.(json.Number); !ok {
+			return fmt.Errorf("%s must be a number", at)
+		}
+	}
+	return nil
+}
+
+// validateRequest is mux middleware rejecting requests to documented routes whose parameters
+// or JSON body do not match the document, before they reach the handler
+func (d *OpenAPI) validateRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		op := d.operations[gmux.CurrentRoute(r)][r.Method]
+		if op == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		fail := func(status int, message string) {
+			requestError(w, r, message, status)
+		}
+
+		query := r.URL.Query()
+		for _, p := range op.AllParams {
+			var msg string
+			switch p.In {
+			case "query":
+				_, present := query[p.Name]
+				msg = p.check(query.Get(p.Name), present)
+			case "form":
+				value := r.FormValue(p.Name)
+				_, present := r.Form[p.Name]
+				if p.Type == "file" {
+					_, _, err := r.FormFile(p.Name)
+					present = err == nil
+				}
+				msg = p.check(value, present)
+			}
+			if msg != "" {
+				fail(http.StatusBadRequest, msg)
+				return
+			}
+		}
+
+		if op.BodySchema != nil {
+			if ct := r.Header.Get("Content-Type"); ct != "" {
+				if mediaType, _, _ := mime.ParseMediaType(ct); mediaType != "application/json" {
+					fail(http.StatusUnsupportedMediaType, "request body must be application/json")
+					return
+				}
+			}
+			body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxAPIBody))
+			if err != nil {
+				fail(http.StatusRequestEntityTooLarge, "request body is too large")
+				return
+			}
+			dec := json.NewDecoder(bytes.NewReader(body))
+			dec.UseNumber()
+			var v interface{}
+			if err = dec.Decode(&v); err == io.EOF {
+				fail(http.StatusBadRequest, "request body must be a JSON object")
+				return
+			} else if err != nil {
+				fail(http.StatusBadRequest, "invalid JSON body: "+err.Error())
+				return
+			}
+			if err = d.validate(op.BodySchema, v, "body"); err != nil {
+				fail(http.StatusBadRequest, err.Error())
+				return
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
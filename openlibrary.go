@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const defaultOpenLibraryURL = "https://openlibrary.org"
+
+type openLibrarySearchResponse struct {
+	Docs []struct {
+		Key              string   `json:"key"`
+		Title            string   `json:"title"`
+		AuthorName       []string `json:"author_name"`
+		FirstPublishYear int      `json:"first_publish_year"`
+		ISBN             []string `json:"isbn"`
+		DDC              []string `json:"ddc"`
+		Publisher        []string `json:"publisher"`
+		Language         []string `json:"language"`
+		PageCount        int      `json:"number_of_pages_median"`
+	} `json:"docs"`
+}
+
+// OpenLibraryProvider talks to the Open Library search and works JSON API
+type OpenLibraryProvider struct {
+	BaseURL string
+	Client  *http.Client
+}
+
+func NewOpenLibraryProvider(baseURL string) *OpenLibraryProvider {
+	return &OpenLibraryProvider{BaseURL: baseURL, Client: providerClient}
+}
+
+func (p *OpenLibraryProvider) Name() string {
+	return "openlibrary"
+}
+
+func (p *OpenLibraryProvider) Search(q SearchQuery) ([]SearchResult, error) {
+	params := url.Values{}
+	if q.Title != "" {
+		params.Set("title", q.Title)
+	}
+	if q.Author != "" {
+		params.Set("author", q.Author)
+	}
+	if q.ISBN != "" {
+		params.Set("isbn", q.ISBN)
+	}
+	return p.search(params)
+}
+
+// Find looks a work up by its Open Library key, e.g. OL45883W
+func (p *OpenLibraryProvider) Find(id string) (BookMetadata, error) {
+	var c openLibrarySearchResponse
+	params := url.Values{"q": {"key:/works/" + id},
+		"fields": {"key,title,author_name,first_publish_year,isbn,ddc,publisher,language,number_of_pages_median"}}
+	if err := p.getJSON("/search.json?"+params.Encode(), &c); err != nil {
+		return BookMetadata{}, err
+	}
+	if len(c.Docs) == 0 {
+		return BookMetadata{}, errors.New("openlibrary: no such work: " + id)
+	}
+
+	doc := c.Docs[0]
+	book := BookMetadata{
+		Title:     doc.Title,
+		Author:    strings.Join(doc.AuthorName, ", "),
+		ID:        id,
+		Year:      doc.FirstPublishYear,
+		PageCount: doc.PageCount,
+	}
+	if len(doc.DDC) > 0 {
+		book.Classification = doc.DDC[0]
+	}
+	if len(doc.Publisher) > 0 {
+		book.Publisher = doc.Publisher[0]
+	}
+	if len(doc.Language) > 0 {
+		book.Language = doc.Language[0]
+	}
+	//  a work lists the ISBNs of all its editions, keep the first of each length
+	for _, isbn := range doc.ISBN {
+		if len(isbn) == 10 && book.ISBN10 == "" {
+			book.ISBN10 = isbn
+		} else if len(isbn) == 13 && book.ISBN13 == "" {
+			book.ISBN13 = isbn
+		}
+	}
+	return book, nil
+}
+
+func (p *OpenLibraryProvider) search(params url.Values) ([]SearchResult, error) {
+	var c openLibrarySearchResponse
+	if err := p.getJSON("/search.json?"+params.Encode(), &c); err != nil {
+		return []SearchResult{}, err
+	}
+
+	results := make([]SearchResult, 0, len(c.Docs))
+	for _, doc := range c.Docs {
+		result := SearchResult{
+			Title:  doc.Title,
+			Author: strings.Join(doc.AuthorName, ", "),
+			ID:     strings.TrimPrefix(doc.Key, "/works/"),
+		}
+		if doc.FirstPublishYear != 0 {
+			result.Year = strconv.Itoa(doc.FirstPublishYear)
+		}
+		if len(doc.ISBN) > 0 {
+			result.ISBN = doc.ISBN[0]
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func (p *OpenLibraryProvider) getJSON(path string, v interface{}) error {
+	resp, err := p.Client.Get(p.BaseURL + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("openlibrary: " + resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
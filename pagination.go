@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	bookPageSize    = 50 //books per page of the library view and the API
+	maxBookPageSize = 200
+)
+
+var errInvalidCursor = errors.New("cursor is invalid or belongs to a different sort order")
+
+// columns books can be sorted by
+var bookSortColumns = map[string]bool{
+	"pk": true, "title": true, "author": true, "classification": true, "year": true, "publisher": true,
+	"page_count": true, "language": true, "isbn13": true, "rating": true, "date_read": true,
+}
+
+// at most this many sort keys, pk aside
+const maxSortKeys = 4
+
+// sortKey is one column of a sort order
+type sortKey struct {
+	Column string
+	Desc   bool
+}
+
+// parseBookSort reads a sort order such as "author,-year": columns separated by commas,
+// descending with a leading "-". It returns a message for the client if it is not valid.
+func parseBookSort(s string) ([]sortKey, string) {
+	var keys []sortKey
+	seen := map[string]bool{}
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		key := sortKey{Column: strings.TrimPrefix(field, "-"), Desc: strings.HasPrefix(field, "-")}
+		if !bookSortColumns[key.Column] {
+			return nil, "cannot sort by " + key.Column
+		}
+		if seen[key.Column] {
+			return nil, "cannot sort by " + key.Column + " twice"
+		}
+		seen[key.Column] = true
+		keys = append(keys, key)
+	}
+	if len(keys) > maxSortKeys {
+		return nil, "cannot sort by more than " + strconv.Itoa(maxSortKeys) + " columns"
+	}
+	return keys, ""
+}
+
+func formatBookSort(keys []sortKey) string {
+	fields := make([]string, len(keys))
+	for i, key := range keys {
+		fields[i] = key.Column
+		if key.Desc {
+			fields[i] = "-" + key.Column
+		}
+	}
+	return strings.Join(fields, ",")
+}
+
+// stableSort ends keys with pk, which is unique, so that the order and the pages are stable
+func stableSort(keys []sortKey) []sortKey {
+	for i, key := range keys {
+		if key.Column == "pk" {
+			return keys[:i+1]
+		}
+	}
+	return append(keys[:len(keys):len(keys)], sortKey{Column: "pk"})
+}
+
+// bookPage selects part of a listing of books. The zero value selects them all.
+type bookPage struct {
+	Limit  int    //0 for no limit
+	Offset int    //books to skip, when not paging by cursor
+	After  string //cursor of the last book of the previous page
+	Next   string //set by selectBooks to the cursor of the next page, "" on the last one
+}
+
+// bookCursor is where a page of books ended, for keyset pagination: the next page starts after
+// the book with these values of the sort columns. Unlike an offset it stays put when books
+// are added.
+type bookCursor struct {
+	Sort   string            `json:"s"` //the sort order, as formatBookSort writes it
+	Values []json.RawMessage `json:"v"`
+}
+
+// bookField returns the index of the Book field stored in column
+func bookField(column string) (int, bool) {
+	t := reflect.TypeOf(Book{})
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get("db") == column {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func encodeBookCursor(b Book, keys []sortKey) string {
+	c := bookCursor{Sort: formatBookSort(keys)}
+	for _, key := range keys {
+		i, _ := bookField(key.Column)
+		value, _ := json.Marshal(reflect.ValueOf(b).Field(i).Interface())
+		c.Values = append(c.Values, value)
+	}
+	cursor, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(cursor)
+}
+
+// decodeBookCursor returns the values of the sort columns in a cursor made for the same sort order
+func decodeBookCursor(cursor string, keys []sortKey) ([]interface{}, error) {
+	var c bookCursor
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || json.Unmarshal(raw, &c) != nil || c.Sort != formatBookSort(keys) || len(c.Values) != len(keys) {
+		return nil, errInvalidCursor
+	}
+	values := make([]interface{}, len(keys))
+	for n, key := range keys {
+		i, ok := bookField(key.Column)
+		if !ok {
+			return nil, errInvalidCursor
+		}
+		value := reflect.New(reflect.TypeOf(Book{}).Field(i).Type)
+		if err := json.Unmarshal(c.Values[n], value.Interface()); err != nil {
+			return nil, errInvalidCursor
+		}
+		values[n] = value.Elem().Interface()
+	}
+	return values, nil
+}
+
+// bookFilter is the condition selecting the books of a filter, any of them for "all" or ""
+func bookFilter(filterByClass string) string {
+	switch filterByClass {
+	case "fiction":
+		return " and classification between '800' and '900'"
+	case "nonfiction":
+		return " and classification not between '800' and '900'"
+	}
+	return ""
+}
+
+// selectBooks loads the page of the user's books, sorted by keys (by pk when there are none)
+func selectBooks(books *[]Book, keys []sortKey, filterByClass string, username string, page *bookPage) error {
+	keys = stableSort(keys)
+	args := []interface{}{username}
+	where := " where \"user\"=" + dbmap.Dialect.BindVar(0) + bookFilter(filterByClass)
+
+	if page.After != "" {
+		values, err := decodeBookCursor(page.After, keys)
+		if err != nil {
+			return err
+		}
+		//  the rows after the cursor in the sort order: those equal to it on the first
+		//  columns and past it on the next one, for each column in turn
+		var after []string
+		for i := range keys {
+			var cond []string
+			for j := 0; j <= i; j++ {
+				op := "="
+				if j == i {
+					op = ">"
+					if keys[j].Desc {
+						op = "<"
+					}
+				}
+				cond = append(cond, keys[j].Column+op+dbmap.Dialect.BindVar(len(args)))
+				args = append(args, values[j])
+			}
+			after = append(after, "("+strings.Join(cond, " and ")+")")
+		}
+		where += " and (" + strings.Join(after, " or ") + ")"
+	}
+
+	var order []string
+	for _, key := range keys {
+		if key.Desc {
+			order = append(order, key.Column+" desc")
+		} else {
+			order = append(order, key.Column+" asc")
+		}
+	}
+	query := "select * from books" + where + " order by " + strings.Join(order, ", ")
+	if page.Limit > 0 {
+		//  one more than asked for tells whether there is a next page
+		query += " limit " + strconv.Itoa(page.Limit+1) + " offset " + strconv.Itoa(page.Offset)
+	}
+
+	if _, err := dbmap.Select(books, query, args...); err != nil {
+		return err
+	}
+	page.Next = ""
+	if page.Limit > 0 && len(*books) > page.Limit {
+		*books = (*books)[:page.Limit]
+		page.Next = encodeBookCursor((*books)[page.Limit-1], keys)
+	}
+	return nil
+}
+
+// parseBookPage reads the limit, offset and cursor query parameters, returning a message for
+// the client if they are not valid. Paging by offset or cursor without a limit uses
+// defaultLimit, as does every request when it is not 0.
+func parseBookPage(r *http.Request, defaultLimit int) (bookPage, string) {
+	var page bookPage
+	q := r.URL.Query()
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxBookPageSize {
+			return page, "limit must be between 1 and " + strconv.Itoa(maxBookPageSize)
+		}
+		page.Limit = n
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return page, "offset must be a number of books to skip"
+		}
+		page.Offset = n
+	}
+	page.After = q.Get("cursor")
+	if page.After != "" && page.Offset > 0 {
+		return page, "use either offset or cursor"
+	}
+	if page.Limit == 0 && (defaultLimit > 0 || page.Offset > 0 || page.After != "") {
+		page.Limit = defaultLimit
+		if page.Limit == 0 {
+			page.Limit = bookPageSize
+		}
+	}
+	return page, ""
+}
+
+// pageLink is the URL of the page after page, listed by path with the query q
+func pageLink(path string, q url.Values, page *bookPage) string {
+	if page.Next == "" {
+		return ""
+	}
+	next := url.Values{}
+	for k, v := range q {
+		next[k] = v
+	}
+	next.Del("offset")
+	next.Set("cursor", page.Next)
+	next.Set("limit", strconv.Itoa(page.Limit))
+	return path + "?" + next.Encode()
+}
+
+// setPageLinks sets the Link header of a listing by path with the query q to its next page,
+// if there is one
+func setPageLinks(w http.ResponseWriter, path string, q url.Values, page *bookPage) {
+	if link := pageLink(path, q, page); link != "" {
+		w.Header().Set("Link", "<"+link+">; rel=\"next\"")
+	}
+}
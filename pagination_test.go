@@ -0,0 +1,189 @@
+package main
+
+import (
+	"database/sql"
+	"reflect"
+	"sort"
+	"testing"
+
+	"gopkg.in/gorp.v1"
+)
+
+// openTestDB points dbmap at a migrated in-memory SQLite database
+func openTestDB(t *testing.T) {
+	var err error
+	if db, err = sql.Open("sqlite3", ":memory:"); err != nil {
+		t.Fatal(err)
+	}
+	//  every connection to :memory: is a database of its own
+	db.SetMaxOpenConns(1)
+	dbmap = &gorp.DbMap{Db: db, Dialect: gorp.SqliteDialect{}}
+	dbmap.AddTableWithName(Book{}, "books").SetKeys(true, "pk")
+	if err = migrateUp(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// books with ties on every column but pk, so that each sort key matters
+var pagedBooks = []Book{
+	{Title: "Dune", Author: "Herbert", Year: 1965, Classification: "813.54"},
+	{Title: "Children of Dune", Author: "Herbert", Year: 1976, Classification: "813.54"},
+	{Title: "Dune Messiah", Author: "Herbert", Year: 1969, Classification: "813.54"},
+	{Title: "Cosmos", Author: "Sagan", Year: 1980, Classification: "520"},
+	{Title: "Contact", Author: "Sagan", Year: 1985, Classification: "813.54"},
+	{Title: "Pale Blue Dot", Author: "Sagan", Year: 1994, Classification: "919.904"},
+	{Title: "Solaris", Author: "Lem", Year: 1961, Classification: "891.8537"},
+	{Title: "Solaris", Author: "Lem", Year: 1961, Classification: "891.8537"},
+	{Title: "The Cyberiad", Author: "Lem", Year: 1965, Classification: "891.8537"},
+	{Title: "Emma", Author: "Austen", Year: 1815, Classification: "823.7"},
+	{Title: "Persuasion", Author: "Austen", Year: 1817, Classification: "823.7"},
+	{Title: "Untitled", Author: "", Year: 0, Classification: ""},
+}
+
+func insertPagedBooks(t *testing.T) {
+	for _, user := range []string{"alice", "bob"} {
+		for _, b := range pagedBooks {
+			b.User = user
+			if err := dbmap.Insert(&b); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+// sortedPKs is the order selectBooks should list the user's books in, worked out in Go
+func sortedPKs(t *testing.T, keys []sortKey, filter string, username string) []int64 {
+	var books []Book
+	if _, err := dbmap.Select(&books, "select * from books where \"user\"=?"+bookFilter(filter), username); err != nil {
+		t.Fatal(err)
+	}
+	keys = stableSort(keys)
+	sort.Slice(books, func(i, j int) bool {
+		for _, key := range keys {
+			field, _ := bookField(key.Column)
+			a := reflect.ValueOf(books[i]).Field(field)
+			b := reflect.ValueOf(books[j]).Field(field)
+			var less, greater bool
+			switch a.Kind() {
+			case reflect.String:
+				less, greater = a.String() < b.String(), a.String() > b.String()
+			case reflect.Int, reflect.Int64:
+				less, greater = a.Int() < b.Int(), a.Int() > b.Int()
+			case reflect.Float64:
+				less, greater = a.Float() < b.Float(), a.Float() > b.Float()
+			}
+			if key.Desc {
+				less, greater = greater, less
+			}
+			if less || greater {
+				return less
+			}
+		}
+		return false
+	})
+	pks := make([]int64, len(books))
+	for i, b := range books {
+		pks[i] = b.PK
+	}
+	return pks
+}
+
+func TestSelectBooksKeysetPages(t *testing.T) {
+	openTestDB(t)
+	defer db.Close()
+	insertPagedBooks(t)
+
+	for _, test := range []struct {
+		sort   string
+		filter string
+	}{
+		{"", ""},
+		{"title", ""},
+		{"-year", ""},
+		{"-pk", ""},
+		{"author,-year", ""},
+		{"-author,title", ""},
+		{"-author,-year,title", ""},
+		{"year,-title", "fiction"},
+		{"-classification,author", "nonfiction"},
+	} {
+		keys, msg := parseBookSort(test.sort)
+		if msg != "" {
+			t.Fatal(msg)
+		}
+		want := sortedPKs(t, keys, test.filter, "alice")
+
+		for _, limit := range []int{1, 2, 5, len(pagedBooks)} {
+			var got []int64
+			page := bookPage{Limit: limit}
+			for pages := 0; ; pages++ {
+				if pages > len(pagedBooks) {
+					t.Fatalf("sort %q, limit %d: the pages do not end", test.sort, limit)
+				}
+				var books []Book
+				if err := selectBooks(&books, keys, test.filter, "alice", &page); err != nil {
+					t.Fatalf("sort %q, limit %d: %s", test.sort, limit, err)
+				}
+				if len(books) > limit {
+					t.Errorf("sort %q, limit %d: page of %d books", test.sort, limit, len(books))
+				}
+				for _, b := range books {
+					if b.User != "alice" {
+						t.Errorf("sort %q: listed book %d of %s", test.sort, b.PK, b.User)
+					}
+					got = append(got, b.PK)
+				}
+				if page.Next == "" {
+					break
+				}
+				page.After = page.Next
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("sort %q, filter %q, limit %d: pages list %v, want %v", test.sort, test.filter, limit, got, want)
+			}
+		}
+	}
+}
+
+func TestSelectBooksOffsetPages(t *testing.T) {
+	openTestDB(t)
+	defer db.Close()
+	insertPagedBooks(t)
+
+	keys, _ := parseBookSort("author,-year")
+	want := sortedPKs(t, keys, "", "alice")
+	var got []int64
+	for offset := 0; offset < len(want); offset += 5 {
+		var books []Book
+		page := bookPage{Limit: 5, Offset: offset}
+		if err := selectBooks(&books, keys, "", "alice", &page); err != nil {
+			t.Fatal(err)
+		}
+		for _, b := range books {
+			got = append(got, b.PK)
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("pages list %v, want %v", got, want)
+	}
+}
+
+func TestSelectBooksCursorForOtherSort(t *testing.T) {
+	openTestDB(t)
+	defer db.Close()
+	insertPagedBooks(t)
+
+	byAuthor, _ := parseBookSort("author")
+	byYear, _ := parseBookSort("-year")
+	var books []Book
+	page := bookPage{Limit: 2}
+	if err := selectBooks(&books, byAuthor, "", "alice", &page); err != nil {
+		t.Fatal(err)
+	}
+	for _, cursor := range []string{page.Next, "not a cursor", encodeBookCursor(books[0], byAuthor)} {
+		next := bookPage{Limit: 2, After: cursor}
+		if err := selectBooks(&books, byYear, "", "alice", &next); err != errInvalidCursor {
+			t.Errorf("cursor %q for another sort: err = %v, want errInvalidCursor", cursor, err)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/sha1"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordPolicy is configured by PASSWORD_MIN_LENGTH (default 8) and BREACHED_PASSWORDS,
+// a bloom filter file made with "bloom <list> <file>"
+type passwordPolicy struct {
+	MinLength int
+	Breached  *bloomFilter //nil to skip the check
+}
+
+var policy = passwordPolicy{MinLength: 8}
+
+// the cost new hashes are made with; older, cheaper hashes are replaced at login
+var passwordCost = bcrypt.DefaultCost
+
+// bcrypt ignores anything past this many bytes
+const maxPasswordBytes = 72
+
+func initPasswordPolicy() error {
+	if min := os.Getenv("PASSWORD_MIN_LENGTH"); min != "" {
+		n, err := strconv.Atoi(min)
+		if err != nil || n < 1 {
+			return errors.New("PASSWORD_MIN_LENGTH must be a positive number")
+		}
+		policy.MinLength = n
+	}
+	if path := os.Getenv("BREACHED_PASSWORDS"); path != "" {
+		f, err := readBloomFilter(path)
+		if err != nil {
+			return err
+		}
+		policy.Breached = f
+	}
+	if cost := os.Getenv("BCRYPT_COST"); cost != "" {
+		n, err := strconv.Atoi(cost)
+		if err != nil || n < bcrypt.MinCost || n > bcrypt.MaxCost {
+			return errors.New("BCRYPT_COST must be a bcrypt cost")
+		}
+		passwordCost = n
+	}
+	return nil
+}
+
+// check returns why password may not be used by username, as a message for the user
+func (p passwordPolicy) check(password string, username string) error {
+	if len([]rune(password)) < p.MinLength {
+		return errors.New("Passwords must be at least " + strconv.Itoa(p.MinLength) + " characters long")
+	}
+	if len(password) > maxPasswordBytes {
+		return errors.New("Passwords must be at most " + strconv.Itoa(maxPasswordBytes) + " bytes long")
+	}
+	if strings.EqualFold(password, username) {
+		return errors.New("Your password must be different from your username")
+	}
+	if p.Breached != nil && p.Breached.mayContain(sha1.Sum([]byte(password))) {
+		return errors.New("This password has appeared in a data breach, please choose another one")
+	}
+	return nil
+}
+
+func hashPassword(password string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword([]byte(password), passwordCost)
+}
+
+// rehashPassword stores a hash at the current cost for a user who just logged in with
+// password, if theirs was made with a lower one
+func rehashPassword(u *User, password string) {
+	if cost, err := bcrypt.Cost(u.Secret); err != nil || cost >= passwordCost {
+		return
+	}
+	secret, err := hashPassword(password)
+	if err == nil {
+		_, err = dbmap.Exec("update users set secret="+dbmap.Dialect.BindVar(0)+" where username="+dbmap.Dialect.BindVar(1),
+			secret, u.Username)
+	}
+	if err != nil {
+		log.Printf("rehashing password of %s: %s", u.Username, err)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/gorilla/securecookie"
+)
+
+// PasswordReset is a row of the password_resets table. Only a hash of the token is stored,
+// so reading the table does not give a way into anyone's account.
+type PasswordReset struct {
+	TokenHash string `db:"token_hash"`
+	Username  string `db:"username"`
+	CreatedAt int64  `db:"created_at"`
+	ExpiresAt int64  `db:"expires_at"`
+}
+
+const (
+	passwordResetTTL = time.Hour
+	//  at most one reset email per user in this time
+	passwordResetInterval = time.Minute
+)
+
+var errInvalidResetToken = errors.New("invalid or expired password reset token")
+
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// createPasswordReset stores a new reset token for username and returns it, or "" if one
+// was created less than passwordResetInterval ago
+func createPasswordReset(username string) (string, error) {
+	now := time.Now()
+	recent, err := dbmap.SelectInt("select count(*) from password_resets where username="+dbmap.Dialect.BindVar(0)+
+		" and created_at>"+dbmap.Dialect.BindVar(1), username, now.Add(-passwordResetInterval).Unix())
+	if err != nil || recent > 0 {
+		return "", err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(securecookie.GenerateRandomKey(32))
+	reset := &PasswordReset{
+		TokenHash: hashResetToken(token),
+		Username:  username,
+		CreatedAt: now.Unix(),
+		ExpiresAt: now.Add(passwordResetTTL).Unix(),
+	}
+	if err := dbmap.Insert(reset); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// checkPasswordReset returns the user an unexpired token belongs to
+func checkPasswordReset(token string) (string, error) {
+	obj, err := dbmap.Get(PasswordReset{}, hashResetToken(token))
+	if err != nil {
+		return "", err
+	}
+	if obj == nil || obj.(*PasswordReset).ExpiresAt <= time.Now().Unix() {
+		return "", errInvalidResetToken
+	}
+	return obj.(*PasswordReset).Username, nil
+}
+
+// resetPassword uses up the token, along with any other the user was sent, and stores secret
+// as the user's new bcrypt hash. The user's sessions are ended by the caller.
+func resetPassword(token string, secret []byte) (string, error) {
+	username, err := checkPasswordReset(token)
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := dbmap.Begin()
+	if err != nil {
+		return "", err
+	}
+	//  only the request that deletes the token may use it
+	res, err := tx.Exec("delete from password_resets where token_hash="+dbmap.Dialect.BindVar(0), hashResetToken(token))
+	if err == nil {
+		if deleted, _ := res.RowsAffected(); deleted == 0 {
+			err = errInvalidResetToken
+		}
+	}
+	if err == nil {
+		_, err = tx.Exec("delete from password_resets where username="+dbmap.Dialect.BindVar(0), username)
+	}
+	if err == nil {
+		//  the link came by email, so the address is verified too
+		_, err = tx.Exec("update users set secret="+dbmap.Dialect.BindVar(0)+", verified=1 where username="+dbmap.Dialect.BindVar(1),
+			secret, username)
+	}
+	if err != nil {
+		tx.Rollback()
+		return "", err
+	}
+	return username, tx.Commit()
+}
+
+// purgeExpiredPasswordResets deletes expired reset tokens every interval
+func purgeExpiredPasswordResets(interval time.Duration) {
+	for range time.Tick(interval) {
+		if _, err := dbmap.Exec("delete from password_resets where expires_at<="+dbmap.Dialect.BindVar(0), time.Now().Unix()); err != nil {
+			log.Printf("purging password_resets: %s", err)
+		}
+	}
+}
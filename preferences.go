@@ -0,0 +1,71 @@
+package main
+
+import (
+	"log"
+	"net/url"
+	"time"
+)
+
+// UserPreferences is a row of user_preferences: how the user last listed their books, kept
+// in the database rather than the session so it survives logging out and follows them
+// to other devices
+type UserPreferences struct {
+	Username  string `db:"username"`
+	Filter    string `db:"filter"` //"", "all", "fiction" or "nonfiction"
+	Sort      string `db:"sort"`   //as formatBookSort writes it, "" for the order books were added in
+	UpdatedAt int64  `db:"updated_at"`
+}
+
+func validFilter(filter string) bool {
+	switch filter {
+	case "", "all", "fiction", "nonfiction":
+		return true
+	}
+	return false
+}
+
+// userPreferences returns the user's preferences, the defaults if they have none yet.
+// Stored values that are no longer valid are dropped.
+func userPreferences(username string) (UserPreferences, error) {
+	prefs := UserPreferences{Username: username}
+	obj, err := dbmap.Get(UserPreferences{}, username)
+	if err != nil || obj == nil {
+		return prefs, err
+	}
+	prefs = *obj.(*UserPreferences)
+	if !validFilter(prefs.Filter) {
+		prefs.Filter = ""
+	}
+	if _, msg := parseBookSort(prefs.Sort); msg != "" {
+		log.Printf("dropping the sort preference of %s: %s", username, msg)
+		prefs.Sort = ""
+	}
+	return prefs, nil
+}
+
+// sortKeys is the parsed Sort, which userPreferences made sure is valid
+func (p UserPreferences) sortKeys() []sortKey {
+	keys, _ := parseBookSort(p.Sort)
+	return keys
+}
+
+// query is the query string of a listing with these preferences
+func (p UserPreferences) query() url.Values {
+	q := url.Values{}
+	if p.Filter != "" {
+		q.Set("filter", p.Filter)
+	}
+	if p.Sort != "" {
+		q.Set("sort", p.Sort)
+	}
+	return q
+}
+
+func saveUserPreferences(prefs UserPreferences) error {
+	prefs.UpdatedAt = time.Now().Unix()
+	updated, err := dbmap.Update(&prefs)
+	if err == nil && updated == 0 {
+		err = dbmap.Insert(&prefs)
+	}
+	return err
+}
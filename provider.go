@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetadataProvider is implemented by every catalog the app can look books up in
+type MetadataProvider interface {
+	//  Name identifies the provider in SearchResult.Source and the add book route
+	Name() string
+	//  Search returns the works matching any of the non-empty fields of the query
+	Search(q SearchQuery) ([]SearchResult, error)
+	//  Find fetches a single work by the provider's own work ID
+	Find(id string) (BookMetadata, error)
+}
+
+// SearchQuery holds the fields a provider can be searched by
+type SearchQuery struct {
+	Title  string
+	Author string
+	ISBN   string
+}
+
+// BookMetadata is the provider independent description of a single work
+type BookMetadata struct {
+	Title          string
+	Author         string
+	Classification string
+	ID             string
+	ISBN10         string
+	ISBN13         string
+	Year           int
+	Publisher      string
+	Edition        string
+	PageCount      int
+	Language       string
+}
+
+// enabled providers, in the order their results are merged
+var providers []MetadataProvider
+
+// providerClient bounds every provider request so a slow catalog cannot hang a handler or worker
+var providerClient = &http.Client{Timeout: 10 * time.Second}
+
+func initProvider() {
+	names := os.Getenv("METADATA_PROVIDERS")
+	if names == "" {
+		names = "classify,openlibrary,googlebooks"
+	}
+	for _, name := range strings.Split(names, ",") {
+		switch strings.TrimSpace(name) {
+		case "classify":
+			classify := NewClassifyProvider(getenvDefault("CLASSIFY_URL", defaultClassifyURL))
+			classify.Cache = newResponseCache("classify", 1000, 7*24*time.Hour, time.Hour)
+			go purgeExpiredCache(time.Hour)
+			providers = append(providers, classify)
+		case "openlibrary":
+			providers = append(providers, NewOpenLibraryProvider(getenvDefault("OPENLIBRARY_URL", defaultOpenLibraryURL)))
+		case "googlebooks":
+			providers = append(providers, NewGoogleBooksProvider(getenvDefault("GOOGLE_BOOKS_URL", defaultGoogleBooksURL),
+				os.Getenv("GOOGLE_BOOKS_API_KEY")))
+		case "":
+		default:
+			log.Printf("unknown metadata provider %q", name)
+		}
+	}
+}
+
+func getenvDefault(key, def string) string {
+	if val := os.Getenv(key); val != "" {
+		return val
+	}
+	return def
+}
+
+// atoi parses the numeric fields providers send as strings, treating anything unparseable as unknown
+func atoi(s string) int {
+	n, _ := strconv.Atoi(strings.TrimSpace(s))
+	return n
+}
+
+func getProvider(name string) MetadataProvider {
+	for _, p := range providers {
+		if p.Name() == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// find fetches a work from the named provider, falling back to Classify for requests without a source
+func find(source string, id string) (BookMetadata, error) {
+	if source == "" {
+		source = "classify"
+	}
+	p := getProvider(source)
+	if p == nil {
+		return BookMetadata{}, errors.New("unknown metadata provider: " + source)
+	}
+	return p.Find(id)
+}
+
+var errNotFound = errors.New("not found")
+
+// findByISBN searches every provider for the ISBN and fetches the first work found,
+// returning errNotFound when no provider knows it
+func findByISBN(isbn string) (BookMetadata, error) {
+	results, err := search(SearchQuery{ISBN: isbn})
+	if err != nil {
+		return BookMetadata{}, err
+	}
+	if len(results) == 0 {
+		return BookMetadata{}, errNotFound
+	}
+	book, err := find(results[0].Source, results[0].ID)
+	if err != nil {
+		return BookMetadata{}, err
+	}
+	if book.ID == "" {
+		book.ID = results[0].ID
+	}
+	return book, nil
+}
+
+// search queries all enabled providers concurrently and merges their results, dropping duplicates.
+// It only fails when every provider failed.
+func search(q SearchQuery) ([]SearchResult, error) {
+	results := make([][]SearchResult, len(providers))
+	errs := make([]error, len(providers))
+
+	var wg sync.WaitGroup
+	for i, p := range providers {
+		wg.Add(1)
+		go func(i int, p MetadataProvider) {
+			defer wg.Done()
+			results[i], errs[i] = p.Search(q)
+			for j := range results[i] {
+				results[i][j].Source = p.Name()
+			}
+		}(i, p)
+	}
+	wg.Wait()
+
+	merged := []SearchResult{}
+	seen := map[string]bool{}
+	var firstErr error
+	failed := 0
+	for i := range providers {
+		if errs[i] != nil {
+			log.Printf("%s search failed: %s", providers[i].Name(), errs[i])
+			if firstErr == nil {
+				firstErr = errs[i]
+			}
+			failed++
+			continue
+		}
+		for _, result := range results[i] {
+			key := result.Source + ":" + result.ID
+			//  providers disagree on ISBN-10 or 13 and on hyphens, so compare ISBN-13s
+			if _, isbn13, err := parseISBN(result.ISBN); err == nil {
+				key = "isbn:" + isbn13
+			}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			merged = append(merged, result)
+		}
+	}
+	if len(providers) > 0 && failed == len(providers) {
+		return merged, firstErr
+	}
+	return merged, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"html"
+	"net/http"
+	"strings"
+
+	"gopkg.in/gorp.v1"
+)
+
+// BookHit is a book matched by a full-text search of the user's own library
+type BookHit struct {
+	Book
+	Rank    float64 `db:"rank"`    //higher is more relevant
+	Snippet string  `db:"snippet"` //matched text, HTML escaped, with the terms wrapped in <b></b>
+}
+
+// the databases mark the matched terms with these, which cannot be confused with the book's
+// own text once it is escaped
+const (
+	snippetStart = "\x02"
+	snippetStop  = "\x03"
+)
+
+// highlightSnippet escapes a snippet marked up by the database and turns its marks into <b></b>
+func highlightSnippet(snippet string) string {
+	return strings.NewReplacer(snippetStart, "<b>", snippetStop, "</b>").Replace(html.EscapeString(snippet))
+}
+
+// the columns covered by the full-text index, created by migration 0015 as it is here.
+// SQLite only gets FTS5 when built with -tags fts5, otherwise searchBooks falls back to LIKE.
+const postgresSearchDocument = "to_tsvector('english', coalesce(title, '') || ' ' || coalesce(author, '') || ' ' || " +
+	"coalesce(classification, '') || ' ' || coalesce(notes, ''))"
+
+// searchBooks ranks the user's books against the free text query q
+func searchBooks(hits *[]BookHit, q string, username string, w http.ResponseWriter) bool {
+	if strings.TrimSpace(q) == "" {
+		return true
+	}
+	var query string
+	args := []interface{}{q, username}
+
+	switch {
+	case isPostgres():
+		query = "select books.*, ts_rank(" + postgresSearchDocument + ", plainto_tsquery('english', $1)) as rank, " +
+			"ts_headline('english', coalesce(title, '') || ' ' || coalesce(author, '') || ' ' || coalesce(notes, ''), " +
+			"plainto_tsquery('english', $1), 'StartSel=' || chr(2) || ', StopSel=' || chr(3) || ', MaxFragments=2') as snippet " +
+			"from books where " + postgresSearchDocument + " @@ plainto_tsquery('english', $1) and \"user\"=$2 " +
+			"order by rank desc"
+	case sqliteFTS5:
+		args[0] = fts5Query(q)
+		query = "select books.*, -bm25(books_fts) as rank, snippet(books_fts, -1, char(2), char(3), '...', 12) as snippet " +
+			"from books_fts join books on books.pk = books_fts.rowid " +
+			"where books_fts match ? and books.\"user\"=? order by rank desc"
+	default:
+		args[0] = "%" + q + "%"
+		query = "select books.*, 0 as rank, '' as snippet from books " +
+			"where (title like ?1 or author like ?1 or classification like ?1 or notes like ?1) and \"user\"=?2 order by pk"
+	}
+
+	if _, err := dbmap.Select(hits, query, args...); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	for i := range *hits {
+		(*hits)[i].Snippet = highlightSnippet((*hits)[i].Snippet)
+	}
+	return true
+}
+
+// fts5Query turns free text into an FTS5 query matching every word as a prefix,
+// so user input can never be parsed as FTS5 syntax
+func fts5Query(q string) string {
+	var terms []string
+	for _, word := range strings.Fields(q) {
+		terms = append(terms, `"`+strings.Replace(word, `"`, `""`, -1)+`"*`)
+	}
+	return strings.Join(terms, " ")
+}
+
+func isPostgres() bool {
+	_, ok := dbmap.Dialect.(gorp.PostgresDialect)
+	return ok
+}
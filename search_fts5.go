@@ -0,0 +1,7 @@
+//go:build fts5
+// +build fts5
+
+package main
+
+// built against the vendored go-sqlite3 with SQLITE_ENABLE_FTS5
+const sqliteFTS5 = true
@@ -0,0 +1,6 @@
+//go:build !fts5
+// +build !fts5
+
+package main
+
+const sqliteFTS5 = false
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// development only, so a fresh checkout runs without configuration
+const devSessionSecret = "my-secret-123"
+
+// sessionKeyPairs reads the session cookie keys from SESSION_KEYS: a comma separated list of
+// base64 "authentication[:encryption]" pairs. The first pair signs new cookies and the others
+// are still accepted, so a new key can be put in front and the old one dropped once the
+// sessions it signed have expired. SESSION_SECRET is accepted as a single authentication key.
+func sessionKeyPairs() ([][]byte, error) {
+	if keys := strings.TrimSpace(os.Getenv("SESSION_KEYS")); keys != "" {
+		return parseSessionKeys(keys)
+	}
+	if secret := os.Getenv("SESSION_SECRET"); secret != "" {
+		return [][]byte{[]byte(secret), nil}, nil
+	}
+	if os.Getenv("ENV") == "production" {
+		return nil, errors.New("SESSION_KEYS or SESSION_SECRET must be set in production")
+	}
+	log.Print("SESSION_KEYS is not set, signing session cookies with the development key")
+	return [][]byte{[]byte(devSessionSecret), nil}, nil
+}
+
+func parseSessionKeys(keys string) ([][]byte, error) {
+	var pairs [][]byte
+	for i, pair := range strings.Split(keys, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), ":", 2)
+		authKey, err := base64.StdEncoding.DecodeString(parts[0])
+		if err != nil || len(authKey) == 0 {
+			return nil, fmt.Errorf("SESSION_KEYS: pair %d: authentication key is not base64", i+1)
+		}
+		var encryptionKey []byte
+		if len(parts) == 2 && parts[1] != "" {
+			if encryptionKey, err = base64.StdEncoding.DecodeString(parts[1]); err != nil {
+				return nil, fmt.Errorf("SESSION_KEYS: pair %d: encryption key is not base64", i+1)
+			}
+			//  AES-128, 192 or 256
+			if n := len(encryptionKey); n != 16 && n != 24 && n != 32 {
+				return nil, fmt.Errorf("SESSION_KEYS: pair %d: encryption key must be 16, 24 or 32 bytes, got %d", i+1, n)
+			}
+		}
+		pairs = append(pairs, authKey, encryptionKey)
+	}
+	return pairs, nil
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	nSessions "github.com/goincremental/negroni-sessions"
+	"github.com/gorilla/securecookie"
+	gSessions "github.com/gorilla/sessions"
+)
+
+// SessionRecord is a row of the sessions table. The cookie only carries the signed ID.
+type SessionRecord struct {
+	ID         string `db:"id"`
+	Username   string `db:"username"` //copy of the "User" session value, to find a user's sessions
+	Data       string `db:"data"`     //session values, encoded with the store's codecs
+	CreatedAt  int64  `db:"created_at"`
+	UpdatedAt  int64  `db:"updated_at"`
+	ExpiresAt  int64  `db:"expires_at"`
+	IP         string `db:"ip"`
+	UserAgent  string `db:"user_agent"`
+	LastSeenAt int64  `db:"last_seen_at"`
+}
+
+// sessionTouchInterval limits how often a request updates its session's last seen time
+const sessionTouchInterval = time.Minute
+
+// dbStore is a negroni-sessions Store keeping sessions in the database through dbmap,
+// modelled on gorilla's FilesystemStore
+type dbStore struct {
+	Codecs  []securecookie.Codec
+	options *gSessions.Options
+}
+
+// NewDBStore returns a database backed store. keyPairs sign (and optionally encrypt) the
+// session ID cookie and the stored values, as for cookiestore.New.
+func NewDBStore(keyPairs ...[]byte) nSessions.Store {
+	s := &dbStore{
+		Codecs:  securecookie.CodecsFromPairs(keyPairs...),
+		options: &gSessions.Options{Path: "/", MaxAge: 86400 * 30, HttpOnly: true},
+	}
+	s.maxAge(s.options.MaxAge)
+	return s
+}
+
+func (s *dbStore) Options(options nSessions.Options) {
+	s.options = &gSessions.Options{
+		Path:     options.Path,
+		Domain:   options.Domain,
+		MaxAge:   options.MaxAge,
+		Secure:   options.Secure,
+		HttpOnly: options.HTTPOnly,
+	}
+	s.maxAge(options.MaxAge)
+}
+
+func (s *dbStore) maxAge(age int) {
+	for _, codec := range s.Codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(age)
+		}
+	}
+}
+
+// Get returns the session for the request, cached in gorilla's per-request registry
+func (s *dbStore) Get(r *http.Request, name string) (*gSessions.Session, error) {
+	return gSessions.GetRegistry(r).Get(s, name)
+}
+
+// New loads the session named by the request's cookie. A missing, expired or deleted
+// session is not an error: the request just gets a new, empty session.
+func (s *dbStore) New(r *http.Request, name string) (*gSessions.Session, error) {
+	session := gSessions.NewSession(s, name)
+	opts := *s.options
+	session.Options = &opts
+	session.IsNew = true
+
+	c, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	if err = securecookie.DecodeMulti(name, c.Value, &session.ID, s.Codecs...); err != nil {
+		session.ID = ""
+		return session, nil
+	}
+
+	obj, err := dbmap.Get(SessionRecord{}, session.ID)
+	if err != nil {
+		return session, err
+	}
+	if obj == nil || obj.(*SessionRecord).ExpiresAt <= time.Now().Unix() {
+		session.ID = ""
+		return session, nil
+	}
+	if err = securecookie.DecodeMulti(name, obj.(*SessionRecord).Data, &session.Values, s.Codecs...); err != nil {
+		return session, err
+	}
+	session.IsNew = false
+	return session, nil
+}
+
+// Save writes the session row and the ID cookie. A MaxAge <= 0 deletes both.
+func (s *dbStore) Save(r *http.Request, w http.ResponseWriter, session *gSessions.Session) error {
+	if session.Options.MaxAge <= 0 {
+		if session.ID != "" {
+			if _, err := dbmap.Exec("delete from sessions where id="+dbmap.Dialect.BindVar(0), session.ID); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, gSessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	data, err := securecookie.EncodeMulti(session.Name(), session.Values, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	record := &SessionRecord{
+		ID:         session.ID,
+		Data:       data,
+		CreatedAt:  now.Unix(),
+		UpdatedAt:  now.Unix(),
+		ExpiresAt:  now.Add(time.Duration(session.Options.MaxAge) * time.Second).Unix(),
+		IP:         clientIP(r),
+		UserAgent:  r.UserAgent(),
+		LastSeenAt: now.Unix(),
+	}
+	record.Username, _ = session.Values["User"].(string)
+
+	if record.ID != "" {
+		//  only while the session stays with the same user: logging in, registering or logging out
+		//  gets a new ID, so an ID planted in the browser beforehand never becomes a logged in session
+		res, err := dbmap.Exec("update sessions set data="+dbmap.Dialect.BindVar(0)+
+			", updated_at="+dbmap.Dialect.BindVar(1)+", expires_at="+dbmap.Dialect.BindVar(2)+
+			", ip="+dbmap.Dialect.BindVar(3)+", user_agent="+dbmap.Dialect.BindVar(4)+", last_seen_at="+dbmap.Dialect.BindVar(5)+
+			" where id="+dbmap.Dialect.BindVar(6)+" and username="+dbmap.Dialect.BindVar(7),
+			record.Data, record.UpdatedAt, record.ExpiresAt, record.IP, record.UserAgent, record.LastSeenAt, record.ID, record.Username)
+		if err != nil {
+			return err
+		}
+		if updated, _ := res.RowsAffected(); updated == 0 {
+			//  the user changed, or the row was deleted while the request ran, e.g. by a logout
+			//  elsewhere: start over
+			if _, err := dbmap.Exec("delete from sessions where id="+dbmap.Dialect.BindVar(0), record.ID); err != nil {
+				return err
+			}
+			record.ID = ""
+		}
+	}
+	if record.ID == "" {
+		record.ID = newSessionID()
+		if err := dbmap.Insert(record); err != nil {
+			return err
+		}
+		session.ID = record.ID
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, gSessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+// currentSessionID is the ID of the request's stored session, "" if it has not been saved yet
+func currentSessionID(r *http.Request) string {
+	//  the middleware's session is keyed on the request it was given, not on r
+	if s, ok := nSessions.GetSession(r).(interface {
+		Session() *gSessions.Session
+	}); ok && s.Session() != nil {
+		return s.Session().ID
+	}
+	if session, err := sessionStore.Get(r, sessionName); err == nil {
+		return session.ID
+	}
+	return ""
+}
+
+// touchSession records that the request's session was just used, from where and by which browser.
+// Only sessions not seen for sessionTouchInterval are written to.
+func touchSession(r *http.Request) {
+	id := currentSessionID(r)
+	if id == "" {
+		return
+	}
+	now := time.Now()
+	_, err := dbmap.Exec("update sessions set last_seen_at="+dbmap.Dialect.BindVar(0)+", ip="+dbmap.Dialect.BindVar(1)+
+		", user_agent="+dbmap.Dialect.BindVar(2)+" where id="+dbmap.Dialect.BindVar(3)+" and last_seen_at<"+dbmap.Dialect.BindVar(4),
+		now.Unix(), clientIP(r), r.UserAgent(), id, now.Add(-sessionTouchInterval).Unix())
+	if err != nil {
+		log.Printf("touching session: %s", err)
+	}
+}
+
+// behindProxy reports whether requests come through a proxy that appends the client's address
+// to X-Forwarded-For: TRUST_PROXY is set, or the app runs on a Heroku dyno
+func behindProxy() bool {
+	if trust, err := strconv.ParseBool(os.Getenv("TRUST_PROXY")); err == nil {
+		return trust
+	}
+	return os.Getenv("DYNO") != ""
+}
+
+// clientIP is the address the request came from. Behind a trusted proxy that is the last
+// X-Forwarded-For entry, the one the proxy appended itself; anywhere else the header is
+// up to the client and only RemoteAddr can be relied on.
+func clientIP(r *http.Request) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" && behindProxy() {
+		hops := strings.Split(forwarded, ",")
+		return strings.TrimSpace(hops[len(hops)-1])
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// sessionHandle identifies a session on the devices page without revealing its ID
+func sessionHandle(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:8])
+}
+
+// ActiveSession is a row of the devices page
+type ActiveSession struct {
+	Handle    string
+	Current   bool //the session viewing the page
+	CreatedAt time.Time
+	LastSeen  time.Time
+	IP        string
+	UserAgent string
+}
+
+// userSessions lists the user's unexpired sessions, most recently used first
+func userSessions(username string, current string) ([]ActiveSession, error) {
+	var records []SessionRecord
+	_, err := dbmap.Select(&records, "select * from sessions where username="+dbmap.Dialect.BindVar(0)+
+		" and expires_at>"+dbmap.Dialect.BindVar(1)+" order by last_seen_at desc", username, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	active := make([]ActiveSession, 0, len(records))
+	for _, record := range records {
+		active = append(active, ActiveSession{
+			Handle:    sessionHandle(record.ID),
+			Current:   record.ID == current,
+			CreatedAt: time.Unix(record.CreatedAt, 0),
+			LastSeen:  time.Unix(record.LastSeenAt, 0),
+			IP:        record.IP,
+			UserAgent: record.UserAgent,
+		})
+	}
+	return active, nil
+}
+
+// deleteUserSession logs out the user's session with the given handle, reporting whether there was one
+func deleteUserSession(username string, handle string) (bool, error) {
+	var ids []string
+	_, err := dbmap.Select(&ids, "select id from sessions where username="+dbmap.Dialect.BindVar(0), username)
+	if err != nil {
+		return false, err
+	}
+	for _, id := range ids {
+		if sessionHandle(id) == handle {
+			_, err = dbmap.Exec("delete from sessions where id="+dbmap.Dialect.BindVar(0), id)
+			return err == nil, err
+		}
+	}
+	return false, nil
+}
+
+func newSessionID() string {
+	return strings.TrimRight(base32.StdEncoding.EncodeToString(securecookie.GenerateRandomKey(32)), "=")
+}
+
+// invalidateUserSessions deletes every session of the user, for example after a password change.
+// The session with ID except, normally the one making the change, is kept when not empty.
+func invalidateUserSessions(username string, except string) error {
+	_, err := dbmap.Exec("delete from sessions where username="+dbmap.Dialect.BindVar(0)+" and id<>"+dbmap.Dialect.BindVar(1),
+		username, except)
+	return err
+}
+
+// purgeExpiredSessions deletes expired sessions every interval
+func purgeExpiredSessions(interval time.Duration) {
+	for range time.Tick(interval) {
+		if _, err := dbmap.Exec("delete from sessions where expires_at<="+dbmap.Dialect.BindVar(0), time.Now().Unix()); err != nil {
+			log.Printf("purging sessions: %s", err)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"golang.org/x/crypto/bcrypt"
+	"rsc.io/qr"
+)
+
+const (
+	totpIssuer = "go-for-web-dev"
+	totpPeriod = 30 //seconds
+	totpDigits = 6
+	//  codes from this many periods before or after now are accepted, for clocks that drift
+	totpSkew = 1
+
+	backupCodeCount = 10
+	//  how long the password step of a two-step login stays good for
+	twoFactorLoginTTL = 5 * time.Minute
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newTOTPSecret returns a random 160 bit secret, base32 encoded as authenticator apps expect
+func newTOTPSecret() string {
+	return totpEncoding.EncodeToString(securecookie.GenerateRandomKey(20))
+}
+
+// totpCode is the RFC 6238 code for the given time step: HOTP (RFC 4226) with HMAC-SHA1
+func totpCode(secret []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// checkTOTP returns the time step code is valid for. Steps up to lastStep have been used
+// already and are refused, so an observed code cannot be replayed.
+func checkTOTP(encodedSecret string, code string, lastStep int64, now time.Time) (int64, bool) {
+	secret, err := totpEncoding.DecodeString(encodedSecret)
+	code = strings.Replace(code, " ", "", -1)
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step > lastStep && subtle.ConstantTimeCompare([]byte(totpCode(secret, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// totpURI is the otpauth:// URI an authenticator app enrolls from
+func totpURI(username string, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", totpIssuer)
+	v.Set("period", fmt.Sprint(totpPeriod))
+	v.Set("digits", fmt.Sprint(totpDigits))
+	return "otpauth://totp/" + url.PathEscape(totpIssuer+":"+username) + "?" + v.Encode()
+}
+
+// totpQRCode renders the enrollment URI as a PNG QR code
+func totpQRCode(username string, secret string) ([]byte, error) {
+	code, err := qr.Encode(totpURI(username, secret), qr.M)
+	if err != nil {
+		return nil, err
+	}
+	code.Scale = 6
+	return code.PNG(), nil
+}
+
+// backup codes are random, so a fast hash is enough to keep them from whoever reads the table
+func hashBackupCode(code string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.Replace(code, "-", "", -1))))
+	return hex.EncodeToString(sum[:])
+}
+
+// newBackupCodes replaces the user's backup codes and returns the new ones, to show once
+func newBackupCodes(username string) ([]string, error) {
+	tx, err := dbmap.Begin()
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec("delete from backup_codes where username="+dbmap.Dialect.BindVar(0), username)
+	var codes []string
+	for i := 0; i < backupCodeCount && err == nil; i++ {
+		raw := strings.ToLower(totpEncoding.EncodeToString(securecookie.GenerateRandomKey(5)))
+		code := raw[:4] + "-" + raw[4:]
+		codes = append(codes, code)
+		_, err = tx.Exec("insert into backup_codes (username, code_hash) values ("+dbmap.Dialect.BindVar(0)+", "+dbmap.Dialect.BindVar(1)+")",
+			username, hashBackupCode(code))
+	}
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return codes, tx.Commit()
+}
+
+// useBackupCode spends one of the user's backup codes, reporting whether it was valid
+func useBackupCode(username string, code string) (bool, error) {
+	res, err := dbmap.Exec("delete from backup_codes where username="+dbmap.Dialect.BindVar(0)+" and code_hash="+dbmap.Dialect.BindVar(1),
+		username, hashBackupCode(strings.TrimSpace(code)))
+	if err != nil {
+		return false, err
+	}
+	used, _ := res.RowsAffected()
+	return used == 1, nil
+}
+
+// checkSecondFactor accepts a current TOTP code or an unused backup code for the user
+func checkSecondFactor(u *User, code string) (bool, error) {
+	step, ok := checkTOTP(u.TOTPSecret, code, u.TOTPLastStep, time.Now())
+	if !ok {
+		return useBackupCode(u.Username, code)
+	}
+	//  only the request that moves last_step forward may use the code
+	res, err := dbmap.Exec("update users set totp_last_step="+dbmap.Dialect.BindVar(0)+" where username="+dbmap.Dialect.BindVar(1)+
+		" and totp_last_step<"+dbmap.Dialect.BindVar(2), step, u.Username, step)
+	if err != nil {
+		return false, err
+	}
+	updated, _ := res.RowsAffected()
+	return updated == 1, nil
+}
+
+// enableTOTP turns two-factor authentication on with a secret the user proved they enrolled
+func enableTOTP(username string, secret string, step int64) error {
+	_, err := dbmap.Exec("update users set totp_secret="+dbmap.Dialect.BindVar(0)+", totp_enabled=1, totp_last_step="+dbmap.Dialect.BindVar(1)+
+		" where username="+dbmap.Dialect.BindVar(2), secret, step, username)
+	return err
+}
+
+func disableTOTP(username string) error {
+	tx, err := dbmap.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("update users set totp_secret='', totp_enabled=0, totp_last_step=0 where username="+dbmap.Dialect.BindVar(0), username)
+	if err == nil {
+		_, err = tx.Exec("delete from backup_codes where username="+dbmap.Dialect.BindVar(0), username)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// reauthenticate checks the password, and the second factor when the user has one, before
+// a sensitive change. Failures count towards the login throttle like failed logins.
+func reauthenticate(r *http.Request, u *User, password string, code string) (string, error) {
+	keys := loginThrottleKeys(u.Username, clientIP(r))
+	if wait, err := loginBlockedFor(keys); err != nil || wait > 0 {
+		return "Too many failed attempts, try again later", err
+	}
+	ok := bcrypt.CompareHashAndPassword(u.Secret, []byte(password)) == nil
+	if ok && u.TOTPEnabled == 1 {
+		var err error
+		if ok, err = checkSecondFactor(u, code); err != nil {
+			return "", err
+		}
+	}
+	if !ok {
+		if err := recordLoginFailure(keys); err != nil {
+			log.Printf("recording failed login: %s", err)
+		}
+		return "Your password or code is not correct", nil
+	}
+	return "", nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// the SHA-1 test vectors of RFC 6238 appendix B, cut to our six digits
+var rfc6238Secret = []byte("12345678901234567890")
+
+var rfc6238Vectors = []struct {
+	time int64
+	code string //the last six of the RFC's eight digits
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestTOTPCode(t *testing.T) {
+	for _, v := range rfc6238Vectors {
+		if got := totpCode(rfc6238Secret, v.time/totpPeriod); got != v.code {
+			t.Errorf("totpCode at %d = %s, want %s", v.time, got, v.code)
+		}
+	}
+}
+
+func TestCheckTOTP(t *testing.T) {
+	secret := totpEncoding.EncodeToString(rfc6238Secret)
+	for _, v := range rfc6238Vectors {
+		now := time.Unix(v.time, 0)
+		step := v.time / totpPeriod
+		if got, ok := checkTOTP(secret, v.code, 0, now); !ok || got != step {
+			t.Errorf("checkTOTP(%s) at %d = %d, %v, want %d, true", v.code, v.time, got, ok, step)
+		}
+		if _, ok := checkTOTP(secret, v.code[:3]+" "+v.code[3:], 0, now); !ok {
+			t.Errorf("checkTOTP refused %s written with a space", v.code)
+		}
+		if _, ok := checkTOTP(secret, v.code, step, now); ok {
+			t.Errorf("checkTOTP accepted %s again after it was used", v.code)
+		}
+	}
+}
+
+func TestCheckTOTPSkew(t *testing.T) {
+	secret := totpEncoding.EncodeToString(rfc6238Secret)
+	const at = 1111111109
+	step := int64(at / totpPeriod)
+	for _, test := range []struct {
+		offset int64 //periods between the code and now
+		ok     bool
+	}{
+		{-2, false},
+		{-1, true},
+		{0, true},
+		{1, true},
+		{2, false},
+	} {
+		code := totpCode(rfc6238Secret, step+test.offset)
+		got, ok := checkTOTP(secret, code, 0, time.Unix(at, 0))
+		if ok != test.ok || (ok && got != step+test.offset) {
+			t.Errorf("code %d periods away: checkTOTP = %d, %v, want ok %v", test.offset, got, ok, test.ok)
+		}
+	}
+}
+
+func TestCheckTOTPMalformed(t *testing.T) {
+	secret := totpEncoding.EncodeToString(rfc6238Secret)
+	now := time.Unix(59, 0)
+	for _, code := range []string{"", "28708", "2870820", "94287082"} {
+		if _, ok := checkTOTP(secret, code, 0, now); ok {
+			t.Errorf("checkTOTP accepted %q", code)
+		}
+	}
+	if _, ok := checkTOTP("not base32!", "287082", 0, now); ok {
+		t.Error("checkTOTP accepted a code for an undecodable secret")
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/securecookie"
+)
+
+const (
+	verificationTTL = 48 * time.Hour
+	//  at most one verification email per user in this time
+	verificationResendInterval = time.Minute
+)
+
+var errVerificationTooSoon = errors.New("a verification email was sent less than a minute ago")
+
+// verificationCodecs sign the username in verification links, with the session keys but
+// under their own name, so a link cannot pass for a cookie or the other way round
+var verificationCodecs []securecookie.Codec
+
+func initVerification(keyPairs [][]byte) {
+	verificationCodecs = securecookie.CodecsFromPairs(keyPairs...)
+	for _, codec := range verificationCodecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(int(verificationTTL / time.Second))
+		}
+	}
+}
+
+// sendVerificationEmail mails the user a link that marks their address as verified
+func sendVerificationEmail(username string) error {
+	now := time.Now()
+	res, err := dbmap.Exec("update users set verification_sent_at="+dbmap.Dialect.BindVar(0)+
+		" where username="+dbmap.Dialect.BindVar(1)+" and verification_sent_at<="+dbmap.Dialect.BindVar(2),
+		now.Unix(), username, now.Add(-verificationResendInterval).Unix())
+	if err != nil {
+		return err
+	}
+	if updated, _ := res.RowsAffected(); updated == 0 {
+		return errVerificationTooSoon
+	}
+
+	token, err := securecookie.EncodeMulti("verify", username, verificationCodecs...)
+	if err != nil {
+		return err
+	}
+	sendMailAsync(username, "Verify your email address", "Welcome! Please confirm that "+username+
+		" is your email address by opening this link within two days:\n\n"+
+		siteURL+"/verify?token="+token+"\n\nIf you did not register, ignore this email.\n")
+	return nil
+}
+
+// verifyEmail marks the user a verification link was sent to as verified and returns them.
+// An expired or tampered link gives an error.
+func verifyEmail(token string) (string, error) {
+	var username string
+	if err := securecookie.DecodeMulti("verify", token, &username, verificationCodecs...); err != nil {
+		return "", err
+	}
+	_, err := dbmap.Exec("update users set verified=1 where username="+dbmap.Dialect.BindVar(0), username)
+	return username, err
+}
+
+// allowedUnverified is what a user may do before verifying their address: look around,
+// ask for another link and log out
+func allowedUnverified(r *http.Request) bool {
+	switch r.Method {
+	case "GET", "HEAD":
+		return true
+	}
+	return r.URL.Path == "/verify/resend" || r.URL.Path == "/logout"
+}